@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/memcachier/mc"
+)
+
+var ErrCacheMiss = errors.New("cache miss")
+
+const DEFAULT_CACHE_NAMESPACE = "hnt-hmrc"
+
+// ttl is in seconds, Get returns ErrCacheMiss for missing or expired keys
+type Cache interface {
+	Get(key string) (string, error)
+	Set(key string, value string, ttl uint32) error
+	Delete(key string) error
+	Close()
+}
+
+type MemcacheCache struct {
+	client *mc.Client
+}
+
+func newMemcacheCache(server string, username string, password string) *MemcacheCache {
+	return &MemcacheCache{mc.NewMC(server, username, password)}
+}
+
+func (cache *MemcacheCache) Get(key string) (string, error) {
+	val, _, _, err := cache.client.Get(key)
+
+	if errors.Is(err, mc.ErrNotFound) {
+		return "", ErrCacheMiss
+	}
+
+	return val, err
+}
+
+func (cache *MemcacheCache) Set(key string, value string, ttl uint32) error {
+	_, err := cache.client.Set(key, value, 0, ttl, 0)
+
+	return err
+}
+
+func (cache *MemcacheCache) Delete(key string) error {
+	err := cache.client.Del(key)
+
+	if errors.Is(err, mc.ErrNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+func (cache *MemcacheCache) Close() {
+	cache.client.Quit()
+}
+
+/*
+ CACHE_BACKEND picks the cache, "memcache", "memory" or "file". When it
+ isn't set we use memcache if a server is configured, otherwise memory,
+ so the app runs locally without docker-compose.
+*/
+func newCacheFromEnv() (Cache, error) {
+	backend := os.Getenv("CACHE_BACKEND")
+	server := os.Getenv("MEMCACHIER_SERVERS")
+
+	if backend == "" && server != "" {
+		backend = "memcache"
+	}
+
+	if backend == "" {
+		backend = "memory"
+	}
+
+	log.Printf("Using %s cache", backend)
+
+	switch backend {
+	case "memcache":
+		username := os.Getenv("MEMCACHIER_USERNAME")
+		password := os.Getenv("MEMCACHIER_PASSWORD")
+
+		namespace := os.Getenv("CACHE_NAMESPACE")
+
+		if namespace == "" {
+			namespace = DEFAULT_CACHE_NAMESPACE
+		}
+
+		return newChunkedCache(newMemcacheCache(server, username, password), namespace, DEFAULT_CACHE_CHUNK_SIZE), nil
+	case "memory":
+		maxEntries := DEFAULT_CACHE_MAX_ENTRIES
+
+		if value := os.Getenv("CACHE_MAX_ENTRIES"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				return nil, fmt.Errorf("invalid CACHE_MAX_ENTRIES %s", value)
+			}
+
+			maxEntries = parsed
+		}
+
+		return newMemoryCache(maxEntries), nil
+	case "file":
+		dir := os.Getenv("CACHE_DIR")
+
+		if dir == "" {
+			dir = DEFAULT_CACHE_DIR
+		}
+
+		return newFileCache(dir)
+	}
+
+	return nil, fmt.Errorf("unknown cache backend %s", backend)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+)
+
+// memcache refuses items over 1MB, leave room for its own overhead
+const DEFAULT_CACHE_CHUNK_SIZE = 1000 * 1024
+
+// values smaller than this aren't worth compressing
+const CACHE_COMPRESS_THRESHOLD = 1024
+
+const (
+	chunkedRawPrefix      = "r:"
+	chunkedGzipPrefix     = "z:"
+	chunkedManifestPrefix = "m:"
+)
+
+type chunkManifest struct {
+	Chunks   int    `json:"chunks"`
+	Size     int    `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+/*
+ Wraps a cache with limits on key and value size, i.e. memcache. Keys are
+ namespaced and hashed so they always fit in 250 bytes, large values are
+ gzipped and, if still too big, split into chunks with a manifest stored
+ under the key itself.
+*/
+type ChunkedCache struct {
+	inner     Cache
+	namespace string
+	chunkSize int
+}
+
+func newChunkedCache(inner Cache, namespace string, chunkSize int) *ChunkedCache {
+	return &ChunkedCache{inner, namespace, chunkSize}
+}
+
+func (cache *ChunkedCache) hashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+
+	return fmt.Sprintf("%s:%s", cache.namespace, hex.EncodeToString(hash[:]))
+}
+
+// chunk keys include the checksum, so a reader never mixes chunks from two writes
+func chunkKey(hashedKey string, manifest chunkManifest, index int) string {
+	return fmt.Sprintf("%s:%s:%d", hashedKey, manifest.Checksum[:16], index)
+}
+
+func encodeCacheValue(value string) (string, error) {
+	if len(value) < CACHE_COMPRESS_THRESHOLD {
+		return chunkedRawPrefix + value, nil
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+
+	_, err := writer.Write([]byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return chunkedGzipPrefix + buf.String(), nil
+}
+
+func decodeCacheValue(encoded string) (string, error) {
+	if len(encoded) < 2 {
+		return "", fmt.Errorf("cache value is missing its prefix")
+	}
+
+	prefix, body := encoded[:2], encoded[2:]
+
+	switch prefix {
+	case chunkedRawPrefix:
+		return body, nil
+	case chunkedGzipPrefix:
+		reader, err := gzip.NewReader(bytes.NewBufferString(body))
+		if err != nil {
+			return "", err
+		}
+
+		value, err := io.ReadAll(reader)
+		if err != nil {
+			return "", err
+		}
+
+		return string(value), nil
+	}
+
+	return "", fmt.Errorf("unknown cache value prefix %q", prefix)
+}
+
+func (cache *ChunkedCache) Get(key string) (string, error) {
+	hashedKey := cache.hashKey(key)
+
+	stored, err := cache.inner.Get(hashedKey)
+	if err != nil {
+		return "", err
+	}
+
+	if len(stored) < 2 || stored[:2] != chunkedManifestPrefix {
+		return cache.decode(key, stored)
+	}
+
+	var manifest chunkManifest
+	err = json.Unmarshal([]byte(stored[2:]), &manifest)
+	if err != nil || len(manifest.Checksum) < 16 {
+		log.Printf("Corrupt cache manifest for %s", key)
+		return "", ErrCacheMiss
+	}
+
+	var buf bytes.Buffer
+
+	for i := 0; i < manifest.Chunks; i++ {
+		chunk, err := cache.inner.Get(chunkKey(hashedKey, manifest, i))
+
+		// a chunk may have been evicted on its own
+		if err != nil {
+			return "", ErrCacheMiss
+		}
+
+		buf.WriteString(chunk)
+	}
+
+	checksum := sha256.Sum256(buf.Bytes())
+
+	if buf.Len() != manifest.Size || hex.EncodeToString(checksum[:]) != manifest.Checksum {
+		log.Printf("Cache chunks for %s don't match their manifest", key)
+		return "", ErrCacheMiss
+	}
+
+	return cache.decode(key, buf.String())
+}
+
+func (cache *ChunkedCache) decode(key string, encoded string) (string, error) {
+	value, err := decodeCacheValue(encoded)
+	if err != nil {
+		log.Printf("Unable to decode cached %s %s", key, err)
+		return "", ErrCacheMiss
+	}
+
+	return value, nil
+}
+
+func (cache *ChunkedCache) Set(key string, value string, ttl uint32) error {
+	hashedKey := cache.hashKey(key)
+
+	encoded, err := encodeCacheValue(value)
+	if err != nil {
+		return err
+	}
+
+	if len(encoded) <= cache.chunkSize {
+		return cache.inner.Set(hashedKey, encoded, ttl)
+	}
+
+	checksum := sha256.Sum256([]byte(encoded))
+	manifest := chunkManifest{
+		Chunks:   (len(encoded) + cache.chunkSize - 1) / cache.chunkSize,
+		Size:     len(encoded),
+		Checksum: hex.EncodeToString(checksum[:]),
+	}
+
+	// the chunks go in first, so the manifest never points at missing chunks
+	for i := 0; i < manifest.Chunks; i++ {
+		end := (i + 1) * cache.chunkSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+
+		err = cache.inner.Set(chunkKey(hashedKey, manifest, i), encoded[i*cache.chunkSize:end], ttl)
+		if err != nil {
+			return err
+		}
+	}
+
+	jsonData, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	return cache.inner.Set(hashedKey, chunkedManifestPrefix+string(jsonData), ttl)
+}
+
+func (cache *ChunkedCache) Delete(key string) error {
+	hashedKey := cache.hashKey(key)
+
+	stored, err := cache.inner.Get(hashedKey)
+
+	if err == nil && len(stored) >= 2 && stored[:2] == chunkedManifestPrefix {
+		var manifest chunkManifest
+
+		if json.Unmarshal([]byte(stored[2:]), &manifest) == nil && len(manifest.Checksum) >= 16 {
+			for i := 0; i < manifest.Chunks; i++ {
+				cache.inner.Delete(chunkKey(hashedKey, manifest, i))
+			}
+		}
+	}
+
+	return cache.inner.Delete(hashedKey)
+}
+
+func (cache *ChunkedCache) Close() {
+	cache.inner.Close()
+}
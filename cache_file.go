@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_CACHE_DIR = ".cache"
+
+/*
+ Keeps each entry in its own file, named after the hash of its key. The
+ first line of the file is the unix time it expires at, 0 for never.
+*/
+type FileCache struct {
+	dir string
+}
+
+func newFileCache(dir string) (*FileCache, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileCache{dir}, nil
+}
+
+func (cache *FileCache) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+
+	return filepath.Join(cache.dir, hex.EncodeToString(hash[:]))
+}
+
+func (cache *FileCache) Get(key string) (string, error) {
+	contents, err := os.ReadFile(cache.path(key))
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", ErrCacheMiss
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	header, value, found := strings.Cut(string(contents), "\n")
+	if !found {
+		return "", ErrCacheMiss
+	}
+
+	expiry, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return "", ErrCacheMiss
+	}
+
+	if expiry != 0 && time.Now().Unix() > expiry {
+		os.Remove(cache.path(key))
+
+		return "", ErrCacheMiss
+	}
+
+	return value, nil
+}
+
+func (cache *FileCache) Set(key string, value string, ttl uint32) error {
+	var expiry int64
+
+	if ttl != 0 {
+		expiry = expiresAt(ttl).Unix()
+	}
+
+	tmp, err := os.CreateTemp(cache.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.WriteString(strconv.FormatInt(expiry, 10) + "\n" + value)
+	closeErr := tmp.Close()
+
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// renaming is atomic, so readers never see half written entries
+	return os.Rename(tmp.Name(), cache.path(key))
+}
+
+func (cache *FileCache) Delete(key string) error {
+	err := os.Remove(cache.path(key))
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (cache *FileCache) Close() {}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const DEFAULT_CACHE_MAX_ENTRIES = 10000
+
+type memoryCacheEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+// an in-process LRU, the least recently used entry is evicted once it is full
+type MemoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+func newMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func expiresAt(ttl uint32) time.Time {
+	// like memcache, no ttl means the entry never expires
+	if ttl == 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(time.Duration(ttl) * time.Second)
+}
+
+func isExpired(expires time.Time) bool {
+	return !expires.IsZero() && time.Now().After(expires)
+}
+
+func (cache *MemoryCache) Get(key string) (string, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.entries[key]
+	if !ok {
+		return "", ErrCacheMiss
+	}
+
+	entry := element.Value.(*memoryCacheEntry)
+
+	if isExpired(entry.expires) {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+
+		return "", ErrCacheMiss
+	}
+
+	cache.order.MoveToFront(element)
+
+	return entry.value, nil
+}
+
+func (cache *MemoryCache) Set(key string, value string, ttl uint32) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		entry := element.Value.(*memoryCacheEntry)
+		entry.value = value
+		entry.expires = expiresAt(ttl)
+		cache.order.MoveToFront(element)
+
+		return nil
+	}
+
+	cache.entries[key] = cache.order.PushFront(&memoryCacheEntry{key, value, expiresAt(ttl)})
+
+	for cache.order.Len() > cache.maxEntries {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+
+	return nil
+}
+
+func (cache *MemoryCache) Delete(key string) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+	}
+
+	return nil
+}
+
+func (cache *MemoryCache) Close() {}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testCache(t *testing.T, cache Cache) {
+	_, err := cache.Get("missing")
+	if err != ErrCacheMiss {
+		t.Fatalf("Expected a cache miss got %v", err)
+	}
+
+	err = cache.Set("key", "line one\nline two", 60)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	value, err := cache.Get("key")
+	if err != nil || value != "line one\nline two" {
+		t.Fatalf("Unexpected value %q %v", value, err)
+	}
+
+	cache.Delete("key")
+
+	_, err = cache.Get("key")
+	if err != ErrCacheMiss {
+		t.Fatalf("Expected the key to be deleted got %v", err)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	testCache(t, newMemoryCache(10))
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newMemoryCache(2)
+
+	cache.Set("a", "1", 0)
+	cache.Set("b", "2", 0)
+	cache.Get("a")
+	cache.Set("c", "3", 0)
+
+	if _, err := cache.Get("b"); err != ErrCacheMiss {
+		t.Fatalf("Expected b to be evicted")
+	}
+
+	if _, err := cache.Get("a"); err != nil {
+		t.Fatalf("Expected a to be kept")
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	cache, err := newFileCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	testCache(t, cache)
+}
+
+func TestChunkedCache(t *testing.T) {
+	inner := newMemoryCache(100)
+	cache := newChunkedCache(inner, "test", 64)
+
+	testCache(t, cache)
+
+	// random enough that gzip can't squeeze it into a single chunk
+	large := ""
+	for i := 0; len(large) < 4096; i++ {
+		large += fmt.Sprintf("%x", sha256.Sum256([]byte{byte(i)}))
+	}
+
+	key := "https://api.coingecko.com/api/v3/coins/list?" + strings.Repeat("x", 300)
+
+	err := cache.Set(key, large, 0)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	if inner.order.Len() < 3 {
+		t.Fatalf("Expected the value to be chunked, got %d entries", inner.order.Len())
+	}
+
+	for element := inner.order.Front(); element != nil; element = element.Next() {
+		if len(element.Value.(*memoryCacheEntry).key) > 250 {
+			t.Fatalf("Key is too long for memcache")
+		}
+	}
+
+	value, err := cache.Get(key)
+	if err != nil || value != large {
+		t.Fatalf("Unexpected value %v", err)
+	}
+
+	cache.Delete(key)
+
+	if inner.order.Len() != 0 {
+		t.Fatalf("Expected the chunks to be deleted, %d left", inner.order.Len())
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ HMRC treats each token as a "share pool" (Section 104), every acquisition
+ adds its tokens and cost to the pool and a disposal takes out the average
+ cost of the tokens it sells. Rewards are acquired at their income value,
+ so the cost basis is the same GBP value already reported as income.
+ https://www.gov.uk/hmrc-internal-manuals/cryptoassets-manual/crypto22200
+*/
+
+type Acquisition struct {
+	Date   string  `json:"date"`
+	Token  string  `json:"token"`
+	Amount Decimal `json:"amount"`
+	Cost   Decimal `json:"cost"`
+}
+
+type Disposal struct {
+	Date     string  `json:"date" binding:"required"`
+	Token    string  `json:"token" binding:"required"`
+	Amount   Decimal `json:"amount" binding:"required"`
+	Proceeds Decimal `json:"proceeds"`
+	Fees     Decimal `json:"fees"`
+}
+
+type Section104Pool struct {
+	Token  string  `json:"token"`
+	Tokens Decimal `json:"tokens"`
+	Cost   Decimal `json:"cost"`
+}
+
+type MatchRule string
+
+const (
+	MatchSameDay         MatchRule = "same-day"
+	MatchBedAndBreakfast MatchRule = "bed-and-breakfast"
+	MatchSection104      MatchRule = "section-104"
+)
+
+const BED_AND_BREAKFAST_DAYS = 30
+
+// the part of a disposal matched by one rule, pool matches have no acquisition date
+type DisposalMatch struct {
+	Rule            MatchRule `json:"rule"`
+	AcquisitionDate string    `json:"acquisition_date,omitempty"`
+	Amount          Decimal   `json:"amount"`
+	Cost            Decimal   `json:"cost"`
+}
+
+type DisposalResult struct {
+	Date          string          `json:"date"`
+	Token         string          `json:"token"`
+	Amount        Decimal         `json:"amount"`
+	Proceeds      Decimal         `json:"proceeds"`
+	AllowableCost Decimal         `json:"allowable_cost"`
+	Gain          Decimal         `json:"gain"`
+	TaxYear       int             `json:"tax_year"`
+	Matches       []DisposalMatch `json:"matches"`
+	// the rates in force on the day, they can change part way through a year
+	CgtBasicRate  float64 `json:"cgt_basic_rate,omitempty"`
+	CgtHigherRate float64 `json:"cgt_higher_rate,omitempty"`
+}
+
+type TaxYearGains struct {
+	TaxYear        int     `json:"tax_year"`
+	Disposals      int     `json:"disposals"`
+	Proceeds       Decimal `json:"proceeds"`
+	AllowableCosts Decimal `json:"allowable_costs"`
+	Gains          Decimal `json:"gains"`
+	Losses         Decimal `json:"losses"`
+	NetGain        Decimal `json:"net_gain"`
+}
+
+type GainsReport struct {
+	Disposals []DisposalResult `json:"disposals"`
+	Pools     []Section104Pool `json:"pools"`
+	Summaries []TaxYearGains   `json:"summaries"`
+}
+
+type GainsRequest struct {
+	Disposals []Disposal `json:"disposals" binding:"dive"`
+	// tokens held before the rewards in this report, e.g. from earlier years
+	OpeningPools []Section104Pool `json:"opening_pools"`
+}
+
+// each day's rewards are acquired into the pool of the token they were paid in
+func acquisitionsFromDataPoints(data []DataPoint) []Acquisition {
+	var acquisitions []Acquisition
+
+	for _, point := range data {
+		if point.Tokens.Sign() <= 0 {
+			continue
+		}
+
+		acquisitions = append(acquisitions, Acquisition{
+			Date:   point.Date,
+			Token:  point.Token,
+			Amount: point.Tokens,
+			Cost:   point.Earnings,
+		})
+	}
+
+	return acquisitions
+}
+
+func parseDay(date string) (time.Time, error) {
+	tz, _ := time.LoadLocation("Europe/London")
+
+	return time.ParseInLocation("2006-01-02", date, tz)
+}
+
+func (pool *Section104Pool) add(amount Decimal, cost Decimal) {
+	pool.Tokens = pool.Tokens.Add(amount)
+	pool.Cost = pool.Cost.Add(cost)
+}
+
+// takes tokens out of the pool, returning the share of the pooled cost they carry
+func (pool *Section104Pool) remove(amount Decimal) (Decimal, error) {
+	if amount.Cmp(pool.Tokens) > 0 {
+		return Decimal{}, fmt.Errorf("disposal of %s %s exceeds the %s held in the pool", amount, pool.Token, pool.Tokens)
+	}
+
+	if amount.Cmp(pool.Tokens) == 0 {
+		cost := pool.Cost
+		pool.Tokens = Decimal{}
+		pool.Cost = Decimal{}
+
+		return cost, nil
+	}
+
+	cost := pool.Cost.Mul(amount).Div(pool.Tokens, DIVISION_PLACES, DIVISION_ROUNDING)
+	pool.Tokens = pool.Tokens.Sub(amount)
+	pool.Cost = pool.Cost.Sub(cost)
+
+	return cost, nil
+}
+
+// a day's acquisitions of a token, and how much of them is still unmatched
+type acquisitionLot struct {
+	Date      string
+	Token     string
+	Remaining Decimal
+	Cost      Decimal
+	// acquired after the period, it can only match disposals under the bed and breakfast rule
+	later bool
+}
+
+// takes tokens out of the lot along with their share of its cost
+func (lot *acquisitionLot) take(amount Decimal) (Decimal, Decimal) {
+	if amount.Cmp(lot.Remaining) >= 0 {
+		amount, cost := lot.Remaining, lot.Cost
+		lot.Remaining = Decimal{}
+		lot.Cost = Decimal{}
+
+		return amount, cost
+	}
+
+	cost := lot.Cost.Mul(amount).Div(lot.Remaining, DIVISION_PLACES, DIVISION_ROUNDING)
+	lot.Remaining = lot.Remaining.Sub(amount)
+	lot.Cost = lot.Cost.Sub(cost)
+
+	return amount, cost
+}
+
+// all of a day's acquisitions of a token are treated as a single acquisition
+func lotsFromAcquisitions(acquisitions []Acquisition, later []Acquisition) []*acquisitionLot {
+	var lots []*acquisitionLot
+	byKey := make(map[string]*acquisitionLot)
+
+	add := func(acquisition Acquisition, isLater bool) {
+		token := strings.ToLower(acquisition.Token)
+		key := fmt.Sprintf("%s-%s-%t", token, acquisition.Date, isLater)
+
+		if lot, ok := byKey[key]; ok {
+			lot.Remaining = lot.Remaining.Add(acquisition.Amount)
+			lot.Cost = lot.Cost.Add(acquisition.Cost)
+			return
+		}
+
+		lot := &acquisitionLot{
+			Date:      acquisition.Date,
+			Token:     token,
+			Remaining: acquisition.Amount,
+			Cost:      acquisition.Cost,
+			later:     isLater,
+		}
+
+		byKey[key] = lot
+		lots = append(lots, lot)
+	}
+
+	for _, acquisition := range acquisitions {
+		add(acquisition, false)
+	}
+
+	for _, acquisition := range later {
+		add(acquisition, true)
+	}
+
+	sort.SliceStable(lots, func(i, j int) bool {
+		return lots[i].Date < lots[j].Date
+	})
+
+	return lots
+}
+
+// all of a day's disposals of a token, which HMRC treats as a single disposal
+type disposalGroup struct {
+	Token     string
+	Date      string
+	Amount    Decimal
+	Unmatched Decimal
+	Matches   []DisposalMatch
+	// the indexes of the disposals it's made of
+	members []int
+}
+
+/*
+ Splits the group's matches between its disposals in proportion to their
+ amounts, the last one takes what's left so nothing is lost to rounding.
+*/
+func (group *disposalGroup) share(results []DisposalResult) {
+	for _, match := range group.Matches {
+		amountLeft, costLeft := match.Amount, match.Cost
+
+		for n, i := range group.members {
+			amount, cost := amountLeft, costLeft
+
+			if n < len(group.members)-1 {
+				amount = match.Amount.Mul(results[i].Amount).Div(group.Amount, DIVISION_PLACES, DIVISION_ROUNDING).normalize()
+				cost = match.Cost.Mul(results[i].Amount).Div(group.Amount, DIVISION_PLACES, DIVISION_ROUNDING)
+				amountLeft, costLeft = amountLeft.Sub(amount), costLeft.Sub(cost)
+			}
+
+			results[i].AllowableCost = results[i].AllowableCost.Add(cost)
+			results[i].Matches = append(results[i].Matches, DisposalMatch{
+				Rule:            match.Rule,
+				AcquisitionDate: match.AcquisitionDate,
+				Amount:          amount,
+				Cost:            cost,
+			})
+		}
+	}
+}
+
+/*
+ Disposals are matched in the order HMRC sets out: against acquisitions on
+ the same day, then against acquisitions in the following 30 days (the
+ "bed and breakfast" rule) earliest first, and only what is left over comes
+ out of the Section 104 pool. The later acquisitions are only there for
+ the bed and breakfast rule, they never reach the pools.
+ https://www.gov.uk/hmrc-internal-manuals/cryptoassets-manual/crypto22250
+*/
+func calculateGains(acquisitions []Acquisition, later []Acquisition, disposals []Disposal, openingPools []Section104Pool) (GainsReport, error) {
+	pools := make(map[string]*Section104Pool)
+
+	poolFor := func(token string) *Section104Pool {
+		token = strings.ToLower(token)
+
+		if _, ok := pools[token]; !ok {
+			pools[token] = &Section104Pool{Token: token}
+		}
+
+		return pools[token]
+	}
+
+	for _, opening := range openingPools {
+		poolFor(opening.Token).add(opening.Tokens, opening.Cost)
+	}
+
+	sortedDisposals := append([]Disposal{}, disposals...)
+	sort.SliceStable(sortedDisposals, func(i, j int) bool {
+		return sortedDisposals[i].Date < sortedDisposals[j].Date
+	})
+
+	report := GainsReport{}
+	lots := lotsFromAcquisitions(acquisitions, later)
+	results := make([]DisposalResult, len(sortedDisposals))
+
+	var groups []*disposalGroup
+	groupByKey := make(map[string]*disposalGroup)
+
+	for i, disposal := range sortedDisposals {
+		date, err := parseDay(disposal.Date)
+		if err != nil {
+			return report, fmt.Errorf("invalid disposal date %s", disposal.Date)
+		}
+
+		if disposal.Amount.Sign() <= 0 {
+			return report, fmt.Errorf("disposal on %s must be of a positive amount", disposal.Date)
+		}
+
+		results[i] = DisposalResult{
+			Date:     disposal.Date,
+			Token:    strings.ToLower(disposal.Token),
+			Amount:   disposal.Amount,
+			Proceeds: disposal.Proceeds,
+			TaxYear:  taxYearOf(date),
+		}
+		if taxYear, ok := findTaxYear(results[i].TaxYear); ok {
+			results[i].CgtBasicRate, results[i].CgtHigherRate = taxYear.Rates.cgtRatesOn(disposal.Date)
+		}
+
+		key := results[i].Token + "-" + disposal.Date
+
+		group, ok := groupByKey[key]
+		if !ok {
+			group = &disposalGroup{Token: results[i].Token, Date: disposal.Date}
+			groupByKey[key] = group
+			groups = append(groups, group)
+		}
+
+		group.Amount = group.Amount.Add(disposal.Amount)
+		group.Unmatched = group.Unmatched.Add(disposal.Amount)
+		group.members = append(group.members, i)
+	}
+
+	match := func(group *disposalGroup, lot *acquisitionLot, rule MatchRule) {
+		amount, cost := lot.take(group.Unmatched)
+		group.Unmatched = group.Unmatched.Sub(amount)
+
+		group.Matches = append(group.Matches, DisposalMatch{
+			Rule:            rule,
+			AcquisitionDate: lot.Date,
+			Amount:          amount,
+			Cost:            cost,
+		})
+	}
+
+	// same day
+	for _, group := range groups {
+		for _, lot := range lots {
+			if !lot.later && lot.Token == group.Token && lot.Date == group.Date && lot.Remaining.Sign() > 0 {
+				match(group, lot, MatchSameDay)
+			}
+		}
+	}
+
+	// bed and breakfast, earlier disposals get first call on the acquisitions
+	for _, group := range groups {
+		date, _ := parseDay(group.Date)
+		bedAndBreakfastEnd := date.AddDate(0, 0, BED_AND_BREAKFAST_DAYS).Format("2006-01-02")
+
+		for _, lot := range lots {
+			if group.Unmatched.Sign() <= 0 {
+				break
+			}
+
+			if lot.Token != group.Token || lot.Remaining.Sign() <= 0 {
+				continue
+			}
+
+			if lot.Date > group.Date && lot.Date <= bedAndBreakfastEnd {
+				match(group, lot, MatchBedAndBreakfast)
+			}
+		}
+	}
+
+	// section 104, whatever hasn't been matched goes through the pool in date order
+	next := 0
+
+	for _, group := range groups {
+		for next < len(lots) && lots[next].Date <= group.Date {
+			if !lots[next].later {
+				poolFor(lots[next].Token).add(lots[next].Remaining, lots[next].Cost)
+			}
+			next++
+		}
+
+		if group.Unmatched.Sign() > 0 {
+			pool := poolFor(group.Token)
+			cost, err := pool.remove(group.Unmatched)
+			if err != nil {
+				return report, fmt.Errorf("%s on %s", err, group.Date)
+			}
+
+			group.Matches = append(group.Matches, DisposalMatch{
+				Rule:   MatchSection104,
+				Amount: group.Unmatched,
+				Cost:   cost,
+			})
+		}
+
+		group.share(results)
+	}
+
+	for i := range results {
+		results[i].AllowableCost = results[i].AllowableCost.Add(sortedDisposals[i].Fees)
+		results[i].Gain = results[i].Proceeds.Sub(results[i].AllowableCost)
+	}
+
+	for ; next < len(lots); next++ {
+		if !lots[next].later {
+			poolFor(lots[next].Token).add(lots[next].Remaining, lots[next].Cost)
+		}
+	}
+
+	report.Disposals = results
+
+	for _, pool := range pools {
+		report.Pools = append(report.Pools, *pool)
+	}
+
+	sort.SliceStable(report.Pools, func(i, j int) bool {
+		return report.Pools[i].Token < report.Pools[j].Token
+	})
+
+	report.Summaries = summariseGains(report.Disposals)
+
+	return report, nil
+}
+
+/*
+ Rewards from the start of the next tax year, up to the last day a
+ disposal at the end of this one could be bed and breakfast matched.
+*/
+func bedAndBreakfastAcquisitions(nextData []DataPoint, taxYear int) []Acquisition {
+	_, end := taxYearBounds(taxYear)
+	last := end.AddDate(0, 0, BED_AND_BREAKFAST_DAYS-1).Format("2006-01-02")
+
+	var acquisitions []Acquisition
+
+	for _, acquisition := range acquisitionsFromDataPoints(nextData) {
+		if acquisition.Date <= last {
+			acquisitions = append(acquisitions, acquisition)
+		}
+	}
+
+	return acquisitions
+}
+
+// the report's pools are only right for disposals in its tax year
+func checkDisposalsInTaxYear(disposals []Disposal, taxYear int) error {
+	for _, disposal := range disposals {
+		date, err := parseDay(disposal.Date)
+		if err != nil {
+			return fmt.Errorf("invalid disposal date %s", disposal.Date)
+		}
+
+		if taxYearOf(date) != taxYear {
+			return fmt.Errorf("disposal on %s is outside of the %d/%d tax year", disposal.Date, taxYear, taxYear+1)
+		}
+	}
+
+	return nil
+}
+
+func summariseGains(disposals []DisposalResult) []TaxYearGains {
+	byYear := make(map[int]*TaxYearGains)
+
+	for _, disposal := range disposals {
+		if _, ok := byYear[disposal.TaxYear]; !ok {
+			byYear[disposal.TaxYear] = &TaxYearGains{TaxYear: disposal.TaxYear}
+		}
+
+		summary := byYear[disposal.TaxYear]
+		summary.Disposals++
+		summary.Proceeds = summary.Proceeds.Add(disposal.Proceeds)
+		summary.AllowableCosts = summary.AllowableCosts.Add(disposal.AllowableCost)
+
+		if disposal.Gain.Sign() >= 0 {
+			summary.Gains = summary.Gains.Add(disposal.Gain)
+		} else {
+			summary.Losses = summary.Losses.Sub(disposal.Gain)
+		}
+
+		summary.NetGain = summary.Gains.Sub(summary.Losses)
+	}
+
+	var summaries []TaxYearGains
+
+	for _, summary := range byYear {
+		summaries = append(summaries, *summary)
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].TaxYear < summaries[j].TaxYear
+	})
+
+	return summaries
+}
+
+func (report GainsReport) summaryFor(taxYear int) TaxYearGains {
+	for _, summary := range report.Summaries {
+		if summary.TaxYear == taxYear {
+			return summary
+		}
+	}
+
+	return TaxYearGains{TaxYear: taxYear}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"testing"
+)
+
+func d(value string) Decimal {
+	return MustParseDecimal(value)
+}
+
+func TestSection104Pool(t *testing.T) {
+	acquisitions := []Acquisition{
+		{Date: "2023-05-01", Token: "hnt", Amount: d("10"), Cost: d("20")},
+		{Date: "2023-06-01", Token: "hnt", Amount: d("10"), Cost: d("40")},
+	}
+
+	disposals := []Disposal{
+		{Date: "2023-07-01", Token: "HNT", Amount: d("5"), Proceeds: d("25")},
+		// falls in the 2024/2025 tax year
+		{Date: "2024-04-10", Token: "hnt", Amount: d("15"), Proceeds: d("30")},
+	}
+
+	report, err := calculateGains(acquisitions, nil, disposals, nil)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	// pool holds 20 tokens costing 60, so each token costs 3
+	if report.Disposals[0].AllowableCost.Cmp(d("15")) != 0 || report.Disposals[0].Gain.Cmp(d("10")) != 0 {
+		t.Fatalf("Unexpected first disposal %+v", report.Disposals[0])
+	}
+
+	if report.Disposals[1].TaxYear != 2024 || report.Disposals[1].Gain.Cmp(d("-15")) != 0 {
+		t.Fatalf("Unexpected second disposal %+v", report.Disposals[1])
+	}
+
+	summary := report.summaryFor(2023)
+	if summary.Disposals != 1 || summary.NetGain.Cmp(d("10")) != 0 {
+		t.Fatalf("Unexpected summary %+v", summary)
+	}
+
+	if !report.Pools[0].Tokens.IsZero() || !report.Pools[0].Cost.IsZero() {
+		t.Fatalf("Expected an empty pool %+v", report.Pools[0])
+	}
+}
+
+func TestSection104PoolOverdrawn(t *testing.T) {
+	acquisitions := []Acquisition{
+		{Date: "2023-05-01", Token: "hnt", Amount: d("1"), Cost: d("2")},
+	}
+
+	_, err := calculateGains(acquisitions, nil, []Disposal{{Date: "2023-05-02", Token: "hnt", Amount: d("2")}}, nil)
+	if err == nil {
+		t.Fatalf("Expected the disposal to exceed the pool")
+	}
+}
+
+func TestSameDayAndBedAndBreakfast(t *testing.T) {
+	acquisitions := []Acquisition{
+		{Date: "2023-05-01", Token: "hnt", Amount: d("100"), Cost: d("100")},
+		{Date: "2023-06-01", Token: "hnt", Amount: d("1"), Cost: d("5")},
+		{Date: "2023-06-10", Token: "hnt", Amount: d("2"), Cost: d("8")},
+		// outside of the 30 days
+		{Date: "2023-07-05", Token: "hnt", Amount: d("10"), Cost: d("100")},
+	}
+
+	disposals := []Disposal{
+		{Date: "2023-06-01", Token: "hnt", Amount: d("5"), Proceeds: d("20")},
+	}
+
+	report, err := calculateGains(acquisitions, nil, disposals, nil)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	matches := report.Disposals[0].Matches
+	if len(matches) != 3 {
+		t.Fatalf("Expected three matches got %+v", matches)
+	}
+
+	if matches[0].Rule != MatchSameDay || matches[0].Amount.Cmp(d("1")) != 0 || matches[0].Cost.Cmp(d("5")) != 0 {
+		t.Fatalf("Unexpected same day match %+v", matches[0])
+	}
+
+	if matches[1].Rule != MatchBedAndBreakfast || matches[1].AcquisitionDate != "2023-06-10" || matches[1].Amount.Cmp(d("2")) != 0 {
+		t.Fatalf("Unexpected bed and breakfast match %+v", matches[1])
+	}
+
+	// the last 2 tokens come out of the pool at 1 each
+	if matches[2].Rule != MatchSection104 || matches[2].Cost.Cmp(d("2")) != 0 {
+		t.Fatalf("Unexpected pool match %+v", matches[2])
+	}
+
+	if report.Disposals[0].Gain.Cmp(d("5")) != 0 {
+		t.Fatalf("Unexpected gain %+v", report.Disposals[0])
+	}
+
+	// the matched acquisitions never reach the pool
+	if report.Pools[0].Tokens.Cmp(d("108")) != 0 || report.Pools[0].Cost.Cmp(d("198")) != 0 {
+		t.Fatalf("Unexpected pool %+v", report.Pools[0])
+	}
+}
+
+func TestDisposalRatesChangeMidYear(t *testing.T) {
+	acquisitions := []Acquisition{
+		{Date: "2024-05-01", Token: "hnt", Amount: d("3"), Cost: d("3")},
+	}
+
+	disposals := []Disposal{
+		{Date: "2024-10-29", Token: "hnt", Amount: d("1"), Proceeds: d("2")},
+		// the higher rates apply from the day of the budget
+		{Date: "2024-10-30", Token: "hnt", Amount: d("1"), Proceeds: d("2")},
+		{Date: "2025-05-01", Token: "hnt", Amount: d("1"), Proceeds: d("2")},
+	}
+
+	report, err := calculateGains(acquisitions, nil, disposals, nil)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	expected := [][2]float64{{0.10, 0.20}, {0.18, 0.24}, {0.18, 0.24}}
+
+	for i, rates := range expected {
+		if report.Disposals[i].CgtBasicRate != rates[0] || report.Disposals[i].CgtHigherRate != rates[1] {
+			t.Fatalf("Unexpected rates for %s %+v", disposals[i].Date, report.Disposals[i])
+		}
+	}
+}
+
+func TestLaterAcquisitionsOnlyBedAndBreakfast(t *testing.T) {
+	acquisitions := []Acquisition{
+		{Date: "2024-03-01", Token: "hnt", Amount: d("10"), Cost: d("10")},
+	}
+
+	// the next year's rewards, the first 30 days of them
+	later := bedAndBreakfastAcquisitions([]DataPoint{
+		{Date: "2024-04-06", Token: "hnt", Tokens: d("2"), Earnings: d("8")},
+		{Date: "2024-05-05", Token: "hnt", Tokens: d("1"), Earnings: d("5")},
+		{Date: "2024-05-06", Token: "hnt", Tokens: d("4"), Earnings: d("20")},
+	}, 2023)
+
+	if len(later) != 2 || later[1].Date != "2024-05-05" {
+		t.Fatalf("Unexpected later acquisitions %+v", later)
+	}
+
+	disposals := []Disposal{
+		{Date: "2024-04-05", Token: "hnt", Amount: d("4"), Proceeds: d("16")},
+	}
+
+	report, err := calculateGains(acquisitions, later, disposals, nil)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	matches := report.Disposals[0].Matches
+	if len(matches) != 3 || matches[0].Rule != MatchBedAndBreakfast || matches[0].Cost.Cmp(d("8")) != 0 ||
+		matches[1].Rule != MatchBedAndBreakfast || matches[1].AcquisitionDate != "2024-05-05" ||
+		matches[2].Rule != MatchSection104 || matches[2].Cost.Cmp(d("1")) != 0 {
+		t.Fatalf("Unexpected matches %+v", matches)
+	}
+
+	// the closing pool only holds the year's own rewards, the next year's are counted in its own report
+	if report.Pools[0].Tokens.Cmp(d("9")) != 0 || report.Pools[0].Cost.Cmp(d("9")) != 0 {
+		t.Fatalf("Unexpected pool %+v", report.Pools[0])
+	}
+}
+
+func TestCheckDisposalsInTaxYear(t *testing.T) {
+	if err := checkDisposalsInTaxYear([]Disposal{{Date: "2023-04-06"}, {Date: "2024-04-05"}}, 2023); err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	if err := checkDisposalsInTaxYear([]Disposal{{Date: "2024-04-06"}}, 2023); err == nil {
+		t.Fatalf("Expected a disposal outside of the tax year")
+	}
+
+	if err := checkDisposalsInTaxYear([]Disposal{{Date: "5th April"}}, 2023); err == nil {
+		t.Fatalf("Expected an invalid date")
+	}
+}
+
+func TestSameDayDisposalsAreOneDisposal(t *testing.T) {
+	acquisitions := []Acquisition{
+		{Date: "2023-05-01", Token: "hnt", Amount: d("100"), Cost: d("100")},
+		{Date: "2023-06-01", Token: "hnt", Amount: d("2"), Cost: d("10")},
+	}
+
+	disposals := []Disposal{
+		{Date: "2023-06-01", Token: "hnt", Amount: d("3"), Proceeds: d("30")},
+		{Date: "2023-06-01", Token: "HNT", Amount: d("1"), Proceeds: d("10"), Fees: d("1")},
+	}
+
+	report, err := calculateGains(acquisitions, nil, disposals, nil)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	// together they match the day's 2 tokens and take 2 from the pool, each gets its share of both
+	first, second := report.Disposals[0], report.Disposals[1]
+
+	if len(first.Matches) != 2 || first.Matches[0].Rule != MatchSameDay || first.Matches[0].Amount.Cmp(d("1.5")) != 0 ||
+		first.Matches[1].Rule != MatchSection104 || first.Matches[1].Amount.Cmp(d("1.5")) != 0 ||
+		first.AllowableCost.Cmp(d("9")) != 0 || first.Gain.Cmp(d("21")) != 0 {
+		t.Fatalf("Unexpected first disposal %+v", first)
+	}
+
+	if len(second.Matches) != 2 || second.Matches[0].Amount.Cmp(d("0.5")) != 0 || second.Matches[0].Cost.Cmp(d("2.5")) != 0 ||
+		second.AllowableCost.Cmp(d("4")) != 0 || second.Gain.Cmp(d("6")) != 0 {
+		t.Fatalf("Unexpected second disposal %+v", second)
+	}
+
+	if report.Pools[0].Tokens.Cmp(d("98")) != 0 || report.Pools[0].Cost.Cmp(d("98")) != 0 {
+		t.Fatalf("Unexpected pool %+v", report.Pools[0])
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cryptocompare caps a single histoday request at 2000 days
+const CRYPTOCOMPARE_MAX_DAYS = 2000
+
+// a day starting at Time, 00:00 UTC
+type CryptoCompareDay struct {
+	Time int64 `json:"time"`
+	// the price as the day starts, the same instant as coingecko's daily prices
+	Open float64 `json:"open"`
+}
+
+type CryptoCompareHistory struct {
+	Response string `json:"Response"`
+	Message  string `json:"Message"`
+	Data     struct {
+		Data []CryptoCompareDay `json:"Data"`
+	} `json:"Data"`
+}
+
+type CryptoCompareProvider struct {
+	currency string
+}
+
+func (provider *CryptoCompareProvider) Name() string {
+	return "cryptocompare"
+}
+
+func (provider *CryptoCompareProvider) SupportedAssets() []string {
+	return []string{"hnt", "iot", "mobile"}
+}
+
+func (provider *CryptoCompareProvider) DailyPrices(asset string, startTime time.Time, endTime time.Time, cache Cache) (PricesBytime, error) {
+	days := len(daysInRange(startTime, endTime))
+
+	if days > CRYPTOCOMPARE_MAX_DAYS {
+		days = CRYPTOCOMPARE_MAX_DAYS
+	}
+
+	url := fmt.Sprintf(
+		"https://min-api.cryptocompare.com/data/v2/histoday?fsym=%s&tsym=%s&limit=%d&toTs=%d",
+		strings.ToUpper(asset),
+		provider.currency,
+		days,
+		endTime.Unix())
+
+	var history CryptoCompareHistory
+
+	err := fetchValidJson(url, cache, &history, func() error {
+		if history.Response != "Success" {
+			return fmt.Errorf("request failed %s", history.Message)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prices := make(PricesBytime)
+
+	for _, day := range history.Data.Data {
+		// days before the coin was listed come back as zero
+		if day.Open == 0 {
+			continue
+		}
+
+		prices[dateAtStartOfDay(time.Unix(day.Time, 0).UTC())] = day.Open
+	}
+
+	return prices, nil
+}
+
+func (provider *CryptoCompareProvider) SpotPrice(asset string, cache Cache) (float64, error) {
+	url := fmt.Sprintf(
+		"https://min-api.cryptocompare.com/data/price?fsym=%s&tsyms=%s",
+		strings.ToUpper(asset),
+		provider.currency)
+
+	var prices map[string]json.RawMessage
+	var price float64
+
+	err := fetchValidJson(url, cache, &prices, func() error {
+		if json.Unmarshal(prices[provider.currency], &price) != nil {
+			return fmt.Errorf("no %s price for %s", provider.currency, asset)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return price, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// rewards we can't tie to a hotspot, e.g. Solana claims paid out other than through a distribute instruction
+const UNATTRIBUTED_HOTSPOT = "unattributed"
+
+type csvColumn struct {
+	header string
+	value  func(point DataPoint, hotspot *HotspotEarnings) string
+	// the footer value, given the report summary
+	total func(summary ReportSummary) string
+}
+
+/*
+ The columns a CSV export can have, picked with columns=. Earnings are in
+ GBP rounded to pence, earnings_exact has the unrounded value. When the
+ export is broken down by hotspot each row is one hotspot's share of the
+ day, so tokens and earnings are that hotspot's.
+*/
+var csvColumns = map[string]csvColumn{
+	"date": {
+		header: "Date",
+		value: func(point DataPoint, hotspot *HotspotEarnings) string {
+			return point.Date
+		},
+		total: func(summary ReportSummary) string {
+			return "Total"
+		},
+	},
+	"hotspot": {
+		header: "Hotspot",
+		value: func(point DataPoint, hotspot *HotspotEarnings) string {
+			if hotspot == nil {
+				return ""
+			}
+
+			if hotspot.Hotspot == "" {
+				return UNATTRIBUTED_HOTSPOT
+			}
+
+			return hotspot.Hotspot
+		},
+	},
+	"token": {
+		header: "Token",
+		value: func(point DataPoint, hotspot *HotspotEarnings) string {
+			return strings.ToUpper(point.Token)
+		},
+		total: func(summary ReportSummary) string {
+			if token, ok := summary.singleToken(); ok {
+				return strings.ToUpper(token.Token)
+			}
+
+			return ""
+		},
+	},
+	"tokens": {
+		header: "Amount",
+		value: func(point DataPoint, hotspot *HotspotEarnings) string {
+			if hotspot != nil {
+				return hotspot.Tokens.String()
+			}
+
+			return point.Tokens.String()
+		},
+		// different tokens can't be added up
+		total: func(summary ReportSummary) string {
+			if token, ok := summary.singleToken(); ok {
+				return token.Tokens.String()
+			}
+
+			return ""
+		},
+	},
+	"base_units": {
+		header: "Base Units",
+		value: func(point DataPoint, hotspot *HotspotEarnings) string {
+			if hotspot != nil {
+				return strconv.FormatInt(hotspot.BaseUnits, 10)
+			}
+
+			return strconv.FormatInt(point.BaseUnits, 10)
+		},
+	},
+	"price": {
+		header: "Price (GBP)",
+		value: func(point DataPoint, hotspot *HotspotEarnings) string {
+			return point.Price.String()
+		},
+		total: func(summary ReportSummary) string {
+			if token, ok := summary.singleToken(); ok {
+				return token.AveragePrice.String()
+			}
+
+			return ""
+		},
+	},
+	"earnings": {
+		header: "Earnings (GBP)",
+		value: func(point DataPoint, hotspot *HotspotEarnings) string {
+			if hotspot != nil {
+				return hotspot.Earnings.RoundGBP().String()
+			}
+
+			return point.Earnings.RoundGBP().String()
+		},
+		total: func(summary ReportSummary) string {
+			return summary.TotalIncome.String()
+		},
+	},
+	"earnings_exact": {
+		header: "Earnings Exact (GBP)",
+		value: func(point DataPoint, hotspot *HotspotEarnings) string {
+			if hotspot != nil {
+				return hotspot.Earnings.String()
+			}
+
+			return point.Earnings.String()
+		},
+		total: func(summary ReportSummary) string {
+			return summary.TotalIncomeExact.String()
+		},
+	},
+	"price_source": {
+		header: "Price Source",
+		value: func(point DataPoint, hotspot *HotspotEarnings) string {
+			return point.PriceSource
+		},
+	},
+	"price_status": {
+		header: "Price Status",
+		value: func(point DataPoint, hotspot *HotspotEarnings) string {
+			return string(point.PriceStatus)
+		},
+	},
+}
+
+var defaultCSVColumns = []string{"date", "token", "tokens", "price", "earnings", "price_source", "price_status"}
+
+// columns is a comma separated list of column names, the hotspot column is added when broken down by hotspot
+func parseCSVColumns(columns string, byHotspot bool) ([]string, error) {
+	names := defaultCSVColumns
+
+	if strings.TrimSpace(columns) != "" {
+		names = nil
+
+		for _, name := range strings.Split(columns, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+
+			if _, ok := csvColumns[name]; !ok {
+				return nil, fmt.Errorf("unknown column %s", name)
+			}
+
+			names = append(names, name)
+		}
+	}
+
+	if byHotspot && !containsString(names, "hotspot") {
+		names = append([]string{names[0], "hotspot"}, names[1:]...)
+	}
+
+	return names, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeDataCSV(w io.Writer, taxYear int, data []DataPoint, columns []string, byHotspot bool) error {
+	writer := csv.NewWriter(w)
+
+	row := make([]string, len(columns))
+
+	for i, name := range columns {
+		row[i] = csvColumns[name].header
+	}
+
+	err := writer.Write(row)
+	if err != nil {
+		return err
+	}
+
+	for _, point := range data {
+		hotspots := []*HotspotEarnings{nil}
+
+		if byHotspot && len(point.Hotspots) > 0 {
+			hotspots = nil
+
+			for i := range point.Hotspots {
+				hotspots = append(hotspots, &point.Hotspots[i])
+			}
+		}
+
+		for _, hotspot := range hotspots {
+			for i, name := range columns {
+				row[i] = csvColumns[name].value(point, hotspot)
+			}
+
+			err = writer.Write(row)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	summary := summariseReport(taxYear, data)
+
+	for i, name := range columns {
+		row[i] = ""
+
+		if total := csvColumns[name].total; total != nil {
+			row[i] = total(summary)
+		}
+	}
+
+	// the footer needs a label even without a date column
+	if row[0] == "" {
+		row[0] = "Total"
+	}
+
+	err = writer.Write(row)
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
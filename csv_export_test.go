@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteDataCSV(t *testing.T) {
+	data := []DataPoint{
+		{
+			Date:        "2023-05-01",
+			Token:       "hnt",
+			Earnings:    d("1.005"),
+			Tokens:      d("0.5"),
+			Price:       d("2.01"),
+			BaseUnits:   50000000,
+			PriceSource: "coingecko",
+			PriceStatus: PriceExact,
+			Hotspots: []HotspotEarnings{
+				{"", 10000000, d("0.1"), d("0.201")},
+				{"hotspot-a", 40000000, d("0.4"), d("0.804")},
+			},
+		},
+		{Date: "2023-05-02", Token: "hnt", Earnings: d("2"), Tokens: d("1"), Price: d("2"), PriceSource: "a, \"quoted\" source", PriceStatus: PriceInterpolated},
+	}
+
+	columns, err := parseCSVColumns("", false)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	var buf bytes.Buffer
+	err = writeDataCSV(&buf, 2023, data, columns, false)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	expected := "Date,Token,Amount,Price (GBP),Earnings (GBP),Price Source,Price Status\n" +
+		"2023-05-01,HNT,0.5,2.01,1.01,coingecko,exact\n" +
+		"2023-05-02,HNT,1,2,2.00,\"a, \"\"quoted\"\" source\",interpolated\n" +
+		"Total,HNT,1.5,2.00333333,3.01,,\n"
+
+	if buf.String() != expected {
+		t.Fatalf("Unexpected CSV\n%s", buf.String())
+	}
+
+	columns, _ = parseCSVColumns("date,earnings", true)
+	buf.Reset()
+	writeDataCSV(&buf, 2023, data, columns, true)
+
+	expected = "Date,Hotspot,Earnings (GBP)\n" +
+		"2023-05-01,unattributed,0.20\n" +
+		"2023-05-01,hotspot-a,0.80\n" +
+		"2023-05-02,,2.00\n" +
+		"Total,,3.01\n"
+
+	if buf.String() != expected {
+		t.Fatalf("Unexpected CSV\n%s", buf.String())
+	}
+
+	// tokens of different kinds aren't totalled
+	data = append(data, DataPoint{Date: "2023-05-02", Token: "iot", Earnings: d("0.5"), Tokens: d("1000"), Price: d("0.0005")})
+	columns, _ = parseCSVColumns("date,token,tokens,earnings", false)
+	buf.Reset()
+	writeDataCSV(&buf, 2023, data, columns, false)
+
+	expected = "Date,Token,Amount,Earnings (GBP)\n" +
+		"2023-05-01,HNT,0.5,1.01\n" +
+		"2023-05-02,HNT,1,2.00\n" +
+		"2023-05-02,IOT,1000,0.50\n" +
+		"Total,,,3.51\n"
+
+	if buf.String() != expected {
+		t.Fatalf("Unexpected CSV\n%s", buf.String())
+	}
+
+	if _, err := parseCSVColumns("date,nope", false); err == nil {
+		t.Fatalf("Expected an unknown column")
+	}
+}
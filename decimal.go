@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+type RoundingMode int
+
+const (
+	// .5 rounds away from zero, like a till
+	RoundHalfUp RoundingMode = iota
+	// .5 rounds to the nearest even digit, so repeated rounding doesn't drift
+	RoundHalfEven
+	// truncates towards zero
+	RoundDown
+)
+
+// money is reported to HMRC in pounds and pence
+const GBP_PLACES = 2
+const GBP_ROUNDING = RoundHalfUp
+
+// divisions can't be exact, they are carried to this many places
+const DIVISION_PLACES = 18
+const DIVISION_ROUNDING = RoundHalfEven
+
+// the most places either side of the point we'll parse, a huge exponent would never finish
+const MAX_DECIMAL_DIGITS = 300
+
+/*
+ An exact decimal, value * 10^-scale. The zero value is 0, and every
+ operation returns a new Decimal so they can be passed around by value.
+*/
+type Decimal struct {
+	value *big.Int
+	scale int32
+}
+
+var bigTen = big.NewInt(10)
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+func (d Decimal) unscaled() *big.Int {
+	if d.value == nil {
+		return new(big.Int)
+	}
+
+	return d.value
+}
+
+func NewDecimal(value int64, scale int32) Decimal {
+	return Decimal{big.NewInt(value), scale}
+}
+
+// token amounts are integers of the token's smallest unit, e.g. bones for HNT
+func NewDecimalFromBaseUnits(units int64, decimals uint8) Decimal {
+	return NewDecimal(units, int32(decimals))
+}
+
+// uses the shortest representation that reads back as the same float
+func NewDecimalFromFloat(value float64) Decimal {
+	d, err := ParseDecimal(strconv.FormatFloat(value, 'f', -1, 64))
+	if err != nil {
+		// only NaN and Inf can't be formatted as digits
+		return Decimal{}
+	}
+
+	return d
+}
+
+func ParseDecimal(input string) (Decimal, error) {
+	s := strings.TrimSpace(input)
+	exponent := int64(0)
+
+	if index := strings.IndexAny(s, "eE"); index >= 0 {
+		parsed, err := strconv.ParseInt(s[index+1:], 10, 32)
+		if err != nil {
+			return Decimal{}, fmt.Errorf("invalid decimal %q", input)
+		}
+
+		exponent = parsed
+		s = s[:index]
+	}
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	digits := whole + fraction
+
+	if digits == "" || digits == "-" || digits == "+" || strings.ContainsAny(digits[1:], "+-") {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", input)
+	}
+
+	value, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", input)
+	}
+
+	scale := int64(len(fraction)) - exponent
+
+	if scale > MAX_DECIMAL_DIGITS || scale < -MAX_DECIMAL_DIGITS {
+		return Decimal{}, fmt.Errorf("decimal %q is out of range", input)
+	}
+
+	if scale < 0 {
+		value.Mul(value, pow10(int32(-scale)))
+		scale = 0
+	}
+
+	return Decimal{value, int32(scale)}.normalize(), nil
+}
+
+func MustParseDecimal(input string) Decimal {
+	d, err := ParseDecimal(input)
+	if err != nil {
+		panic(err)
+	}
+
+	return d
+}
+
+// drops trailing zeros after the point
+func (d Decimal) normalize() Decimal {
+	value := new(big.Int).Set(d.unscaled())
+	scale := d.scale
+	remainder := new(big.Int)
+
+	for scale > 0 && value.Sign() != 0 {
+		quotient, mod := new(big.Int).QuoRem(value, bigTen, remainder)
+		if mod.Sign() != 0 {
+			break
+		}
+
+		value = quotient
+		scale--
+	}
+
+	if value.Sign() == 0 {
+		scale = 0
+	}
+
+	return Decimal{value, scale}
+}
+
+func (d Decimal) rescale(scale int32) Decimal {
+	if scale <= d.scale {
+		return d
+	}
+
+	value := new(big.Int).Mul(d.unscaled(), pow10(scale-d.scale))
+
+	return Decimal{value, scale}
+}
+
+func align(a Decimal, b Decimal) (Decimal, Decimal) {
+	if a.scale > b.scale {
+		return a, b.rescale(a.scale)
+	}
+
+	return a.rescale(b.scale), b
+}
+
+func (d Decimal) Add(other Decimal) Decimal {
+	a, b := align(d, other)
+
+	return Decimal{new(big.Int).Add(a.unscaled(), b.unscaled()), a.scale}
+}
+
+func (d Decimal) Sub(other Decimal) Decimal {
+	a, b := align(d, other)
+
+	return Decimal{new(big.Int).Sub(a.unscaled(), b.unscaled()), a.scale}
+}
+
+// multiplication is always exact
+func (d Decimal) Mul(other Decimal) Decimal {
+	return Decimal{new(big.Int).Mul(d.unscaled(), other.unscaled()), d.scale + other.scale}.normalize()
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{new(big.Int).Neg(d.unscaled()), d.scale}
+}
+
+func (d Decimal) Div(other Decimal, places int32, mode RoundingMode) Decimal {
+	if other.Sign() == 0 {
+		panic("decimal division by zero")
+	}
+
+	// (a / 10^as) / (b / 10^bs) = a * 10^bs / (b * 10^as)
+	numerator := new(big.Int).Mul(d.unscaled(), pow10(other.scale))
+	denominator := new(big.Int).Mul(other.unscaled(), pow10(d.scale))
+
+	return divideAndRound(numerator, denominator, places, mode)
+}
+
+func (d Decimal) Round(places int32, mode RoundingMode) Decimal {
+	if d.scale <= places {
+		return d.rescale(places)
+	}
+
+	return divideAndRound(d.unscaled(), pow10(d.scale), places, mode)
+}
+
+func (d Decimal) RoundGBP() Decimal {
+	return d.Round(GBP_PLACES, GBP_ROUNDING)
+}
+
+// numerator / denominator to the given places
+func divideAndRound(numerator *big.Int, denominator *big.Int, places int32, mode RoundingMode) Decimal {
+	scaled := new(big.Int).Mul(numerator, pow10(places))
+	quotient, remainder := new(big.Int).QuoRem(scaled, denominator, new(big.Int))
+
+	if remainder.Sign() != 0 && mode != RoundDown {
+		// compare twice the remainder against the denominator to find the half
+		twice := new(big.Int).Abs(remainder)
+		twice.Mul(twice, big.NewInt(2))
+		half := twice.Cmp(new(big.Int).Abs(denominator))
+
+		roundAway := half > 0 || (half == 0 && (mode == RoundHalfUp || quotient.Bit(0) == 1))
+
+		if roundAway {
+			if (scaled.Sign() < 0) != (denominator.Sign() < 0) {
+				quotient.Sub(quotient, big.NewInt(1))
+			} else {
+				quotient.Add(quotient, big.NewInt(1))
+			}
+		}
+	}
+
+	return Decimal{quotient, places}
+}
+
+func (d Decimal) Cmp(other Decimal) int {
+	a, b := align(d, other)
+
+	return a.unscaled().Cmp(b.unscaled())
+}
+
+func (d Decimal) Sign() int {
+	return d.unscaled().Sign()
+}
+
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+func (d Decimal) Float64() float64 {
+	value, _ := strconv.ParseFloat(d.String(), 64)
+
+	return value
+}
+
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.unscaled()).String()
+	sign := ""
+
+	if d.Sign() < 0 {
+		sign = "-"
+	}
+
+	if d.scale <= 0 {
+		return sign + digits + strings.Repeat("0", int(-d.scale))
+	}
+
+	if len(digits) <= int(d.scale) {
+		digits = strings.Repeat("0", int(d.scale)-len(digits)+1) + digits
+	}
+
+	point := len(digits) - int(d.scale)
+
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.String())), nil
+}
+
+// accepts both "1.23" and 1.23, so older cached reports still load
+func (d *Decimal) UnmarshalJSON(buf []byte) error {
+	value := string(bytes.Trim(buf, "\""))
+
+	if value == "null" || value == "" {
+		*d = Decimal{}
+		return nil
+	}
+
+	parsed, err := ParseDecimal(value)
+	if err != nil {
+		return err
+	}
+
+	*d = parsed
+
+	return nil
+}
+
+func sumDecimals(values []Decimal) Decimal {
+	total := Decimal{}
+
+	for _, value := range values {
+		total = total.Add(value)
+	}
+
+	return total
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecimalArithmetic(t *testing.T) {
+	// a million rewards of a single bone add up exactly
+	total := Decimal{}
+	bone := NewDecimalFromBaseUnits(1, HNT_DECIMALS)
+
+	for i := 0; i < 1000000; i++ {
+		total = total.Add(bone)
+	}
+
+	if total.String() != "0.01000000" {
+		t.Fatalf("Unexpected total %s", total)
+	}
+
+	earnings := NewDecimalFromBaseUnits(123456789, HNT_DECIMALS).Mul(NewDecimalFromFloat(3.21))
+	if earnings.String() != "3.9629629269" || earnings.RoundGBP().String() != "3.96" {
+		t.Fatalf("Unexpected earnings %s", earnings)
+	}
+
+	third := MustParseDecimal("1").Div(MustParseDecimal("3"), 4, RoundHalfEven)
+	if third.String() != "0.3333" {
+		t.Fatalf("Unexpected division %s", third)
+	}
+}
+
+func TestDecimalRounding(t *testing.T) {
+	cases := []struct {
+		input    string
+		mode     RoundingMode
+		expected string
+	}{
+		{"1.005", RoundHalfUp, "1.01"},
+		{"1.005", RoundHalfEven, "1.00"},
+		{"1.015", RoundHalfEven, "1.02"},
+		{"1.009", RoundDown, "1.00"},
+		{"-1.005", RoundHalfUp, "-1.01"},
+		{"-1.005", RoundHalfEven, "-1.00"},
+		{"2", RoundHalfUp, "2.00"},
+	}
+
+	for _, c := range cases {
+		rounded := MustParseDecimal(c.input).Round(2, c.mode)
+		if rounded.String() != c.expected {
+			t.Fatalf("Expected %s to round to %s got %s", c.input, c.expected, rounded)
+		}
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	var point DataPoint
+
+	// older cached reports have plain numbers
+	err := json.Unmarshal([]byte(`{"earnings": 1.5e-3, "tokens": "0.00000001", "price": null}`), &point)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	if point.Earnings.String() != "0.0015" || point.Tokens.String() != "0.00000001" || !point.Price.IsZero() {
+		t.Fatalf("Unexpected %+v", point)
+	}
+
+	encoded, _ := json.Marshal(point.Earnings)
+	if string(encoded) != `"0.0015"` {
+		t.Fatalf("Unexpected %s", encoded)
+	}
+
+	if _, err := ParseDecimal("1.2.3"); err == nil {
+		t.Fatalf("Expected an invalid decimal")
+	}
+
+	// amounts posted to /gains mustn't be able to hang the server
+	var disposals []Disposal
+	if err := json.Unmarshal([]byte(`[{"amount": "1e200000000"}]`), &disposals); err == nil {
+		t.Fatalf("Expected an out of range amount")
+	}
+
+	for _, input := range []string{"1e-200000000", "1e301", "1e300"} {
+		_, err := ParseDecimal(input)
+		if (err == nil) != (input == "1e300") {
+			t.Fatalf("Unexpected result for %s %v", input, err)
+		}
+	}
+}
@@ -3,26 +3,70 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/memcachier/mc"
 	"log"
+	neturl "net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type RewardTime time.Time
 
-type EarningsByDay map[time.Time]float64
+// amounts are in the base units of the day's token, 10^-8 HNT (bones) or 10^-6 IOT and MOBILE, see rewardTokenDecimals
+type DayEarnings struct {
+	Total int64
+	// keyed by hotspot address, rewards we can't attribute are under ""
+	ByHotspot map[string]int64
+}
+
+type EarningsByDay map[time.Time]*DayEarnings
+
+// keyed by the reward token, e.g. "iot"
+type EarningsByToken map[string]EarningsByDay
+
+const HNT_DECIMALS = 8
+
+// the tokens hotspots are rewarded in, the L1 only ever paid HNT
+var rewardTokens = []string{"hnt", "iot", "mobile"}
+
+var rewardTokenDecimals = map[string]uint8{
+	"hnt":    HNT_DECIMALS,
+	"iot":    6,
+	"mobile": 6,
+}
 
 // helium api
 type Reward struct {
-	Account   string     `json:"account"`
-	Amount    float64    `json:"amount"`
+	Account string `json:"account"`
+	// the hotspot that earned the reward
+	Gateway string `json:"gateway"`
+	// in the base units of Token
+	Amount    int64      `json:"amount"`
 	Timestamp RewardTime `json:"timestamp"`
+	Token     string     `json:"token,omitempty"`
+	// where an L1 reward was recorded, these tell imported rewards apart
+	Block int64  `json:"block,omitempty"`
+	Hash  string `json:"hash,omitempty"`
+	Type  string `json:"type,omitempty"`
+	// imported L1 rewards are from an ETL dump or the api's pages, see L1_SOURCE_ETL
+	Source string `json:"source,omitempty"`
+}
+
+// L1 rewards don't say, they were always HNT
+func (reward Reward) token() string {
+	if reward.Token == "" {
+		return "hnt"
+	}
+
+	return reward.Token
 }
 
 type Hotspot struct {
 	Name    string `json:"name"`
 	Address string `json:"address"`
+	// on Solana, the key the hotspot's rewards are recorded against and its NFT
+	EntityKey string `json:"entity_key,omitempty"`
+	Asset     string `json:"asset,omitempty"`
 }
 
 type AccountHotspotsResponse struct {
@@ -34,12 +78,49 @@ type HotspotRewardsRewards struct {
 	Cursor string   `json:"cursor"`
 }
 
+/*
+ A transfer_hotspot transaction, from the api's account activity or an
+ ETL dump's fields. The first version named the accounts seller and
+ buyer, the second owner and new_owner.
+*/
+type l1TransferTransaction struct {
+	Type     string          `json:"type"`
+	Hash     string          `json:"hash"`
+	Time     json.RawMessage `json:"time"`
+	Gateway  string          `json:"gateway"`
+	Seller   string          `json:"seller"`
+	Buyer    string          `json:"buyer"`
+	Owner    string          `json:"owner"`
+	NewOwner string          `json:"new_owner"`
+	Height   int64           `json:"height"`
+}
+
+func (tx l1TransferTransaction) transfer() (HotspotTransfer, error) {
+	timestamp, err := parseL1Time(string(tx.Time))
+	if err != nil {
+		return HotspotTransfer{}, err
+	}
+
+	transfer := HotspotTransfer{Hotspot: tx.Gateway, From: tx.Seller, To: tx.Buyer, Timestamp: RewardTime(timestamp), Hash: tx.Hash, Block: tx.Height}
+
+	if tx.Type == "transfer_hotspot_v2" {
+		transfer.From, transfer.To = tx.Owner, tx.NewOwner
+	}
+
+	return transfer, nil
+}
+
+type AccountActivityResponse struct {
+	Data   []l1TransferTransaction `json:"data"`
+	Cursor string                  `json:"cursor"`
+}
+
 type AddressData struct {
-	Balance int `json:balance`
+	Balance int64 `json:"balance"`
 }
 
 type AddressResponse struct {
-	Data AddressData `json:data`
+	Data AddressData `json:"data"`
 }
 
 func (n *RewardTime) UnmarshalJSON(buf []byte) error {
@@ -56,41 +137,41 @@ func (n *RewardTime) UnmarshalJSON(buf []byte) error {
 	return nil
 }
 
-func fetchHotspots(address string, cache *mc.Client) []Hotspot {
-	url := fmt.Sprintf("https://api.helium.io/v1/accounts/%s/hotspots", address)
+func (n RewardTime) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(time.Time(n).Format(time.RFC3339Nano))), nil
+}
 
-	response := fetchUrl(url, cache)
+// the hotspots the account held when the L1 stopped, those held now are on Solana, see fetchSolanaHotspots
+func fetchHotspots(address string, cache Cache) ([]Hotspot, error) {
+	url := fmt.Sprintf("https://api.helium.io/v1/accounts/%s/hotspots", address)
 
 	hotspots := AccountHotspotsResponse{}
 
-	json.Unmarshal(response, &hotspots)
+	err := fetchJson(url, cache, &hotspots)
 
-	return hotspots.Data
+	return hotspots.Data, err
 }
 
-func fetchRewards(address string, cursor string, cache *mc.Client, startTime time.Time, endTime time.Time) ([]Reward, string) {
-	format := "2006-01-02"
-
+func fetchRewards(address string, cursor string, cache Cache, startTime time.Time, endTime time.Time) ([]Reward, string, error) {
+	// exact instants, a bare date would be read as midnight UTC
 	url := fmt.Sprintf(
 		"https://api.helium.io/v1/hotspots/%s/rewards?max_time=%s&min_time=%s",
 		address,
-		endTime.Format(format),
-		startTime.Format(format))
+		neturl.QueryEscape(endTime.UTC().Format(time.RFC3339)),
+		neturl.QueryEscape(startTime.UTC().Format(time.RFC3339)))
 
     if cursor != "" {
         url = fmt.Sprintf("%s&cursor=%s", url, cursor)
     }
 
-	response := fetchUrl(url, cache)
-
 	rewardResponse := HotspotRewardsRewards{}
 
-	json.Unmarshal(response, &rewardResponse)
+	err := fetchJson(url, cache, &rewardResponse)
 
-	return rewardResponse.Data, rewardResponse.Cursor
+	return rewardResponse.Data, rewardResponse.Cursor, err
 }
 
-func fetchAllRewards(address string, cache *mc.Client, startTime time.Time, endTime time.Time) []Reward {
+func fetchAllRewards(address string, cache Cache, startTime time.Time, endTime time.Time) ([]Reward, error) {
 	var allRewards []Reward
 
 	var stop bool = false
@@ -98,7 +179,10 @@ func fetchAllRewards(address string, cache *mc.Client, startTime time.Time, endT
 
 	fmt.Println("fetching rewards")
 	for !stop {
-		rewards, cursor := fetchRewards(address, nextCursor, cache, startTime, endTime)
+		rewards, cursor, err := fetchRewards(address, nextCursor, cache, startTime, endTime)
+		if err != nil {
+			return nil, err
+		}
 
 		stop = cursor == ""
 		nextCursor = cursor
@@ -107,52 +191,207 @@ func fetchAllRewards(address string, cache *mc.Client, startTime time.Time, endT
 	}
 	fmt.Println("fetched rewards")
 
-	return allRewards
+	return allRewards, nil
 }
 
-func fetchAllRewardsForAllHotspots(address string, cache *mc.Client, startTime time.Time, endTime time.Time) []Reward {
-	hotspots := fetchHotspots(address, cache)
-	var allRewards []Reward
+// the hotspot transfers in or out of the account
+func fetchL1Transfers(address string, cache Cache, startTime time.Time, endTime time.Time) ([]HotspotTransfer, error) {
+	if l1RewardStore != nil {
+		return l1RewardStore.TransfersInRange(address, startTime, endTime)
+	}
+
+	var transfers []HotspotTransfer
+	cursor := ""
+
+	for {
+		url := fmt.Sprintf(
+			"https://api.helium.io/v1/accounts/%s/activity?filter_types=transfer_hotspot_v1,transfer_hotspot_v2&max_time=%s&min_time=%s",
+			address,
+			neturl.QueryEscape(endTime.UTC().Format(time.RFC3339)),
+			neturl.QueryEscape(startTime.UTC().Format(time.RFC3339)))
+
+		if cursor != "" {
+			url = fmt.Sprintf("%s&cursor=%s", url, cursor)
+		}
+
+		activity := AccountActivityResponse{}
+
+		err := fetchJson(url, cache, &activity)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tx := range activity.Data {
+			transfer, err := tx.transfer()
+			if err != nil {
+				return nil, err
+			}
+
+			transfers = append(transfers, transfer)
+		}
+
+		if activity.Cursor == "" {
+			break
+		}
+
+		cursor = activity.Cursor
+	}
+
+	return transfers, nil
+}
+
+/*
+ The api lists the hotspots the account owns now, so ones it sold during
+ the report come from its transfers. Each hotspot's rewards are its whole
+ history whoever owned it, the caller credits just the owned part.
+*/
+func fetchAllRewardsForAllHotspots(address string, cache Cache, startTime time.Time, endTime time.Time, windows OwnershipWindows) ([]Reward, error) {
+	hotspots, err := fetchHotspots(address, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
 
 	for _, item := range hotspots {
-		rewards := fetchAllRewards(item.Address, cache, startTime, endTime)
+		addresses = append(addresses, item.Address)
+	}
+
+	for _, hotspot := range windows.hotspots() {
+		if !containsString(addresses, hotspot) {
+			addresses = append(addresses, hotspot)
+		}
+	}
+
+	var allRewards []Reward
+
+	for _, hotspot := range addresses {
+		rewards, err := fetchAllRewards(hotspot, cache, startTime, endTime)
+		if err != nil {
+			return nil, err
+		}
 
 		allRewards = append(allRewards, rewards...)
 	}
 
-	return allRewards
+	return allRewards, nil
 }
 
-func rewardsByDay(address string, cache *mc.Client, startTime time.Time, endTime time.Time) EarningsByDay {
-	allRewards := fetchAllRewardsForAllHotspots(address, cache, startTime, endTime)
+/*
+ Anything before the migration comes from the L1 api, anything after
+ from the wallet's claims on Solana.
+*/
+func fetchWalletRewards(address string, cache Cache, startTime time.Time, endTime time.Time) ([]Reward, error) {
+	var allRewards []Reward
+
+	if startTime.Before(SOLANA_MIGRATION_TIME) {
+		l1EndTime := endTime
+
+		if l1EndTime.After(SOLANA_MIGRATION_TIME) {
+			l1EndTime = SOLANA_MIGRATION_TIME
+		}
+
+		// a hotspot bought after the report still needs its first transfer to the wallet
+		transfers, err := fetchL1Transfers(address, cache, startTime, SOLANA_MIGRATION_TIME)
+		if err != nil {
+			return nil, err
+		}
+
+		windows := ownershipWindows(address, transfers)
+
+		var rewards []Reward
+
+		// the api has shut down, imported rewards are all there is for new reports
+		if l1RewardStore != nil {
+			rewards, err = l1RewardStore.RewardsInRange(address, startTime, l1EndTime)
+		} else {
+			rewards, err = fetchAllRewardsForAllHotspots(address, cache, startTime, l1EndTime, windows)
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	earnings := make(EarningsByDay)
+		allRewards = append(allRewards, windows.credit(rewards)...)
+	}
+
+	if endTime.After(SOLANA_MIGRATION_TIME) {
+		solanaStartTime := startTime
+
+		if solanaStartTime.Before(SOLANA_MIGRATION_TIME) {
+			solanaStartTime = SOLANA_MIGRATION_TIME
+		}
+
+		owner, err := solanaAddressFromHelium(address)
+		if err != nil {
+			return nil, err
+		}
+
+		rewards, transfers, err := fetchSolanaRewards(owner, cache, solanaStartTime, endTime)
+		if err != nil {
+			log.Printf("Unable to fetch solana rewards %s %s", address, err)
+			return nil, err
+		}
+
+		allRewards = append(allRewards, ownershipWindows(owner, transfers).credit(rewards)...)
+	}
+
+	return allRewards, nil
+}
+
+// the wallet's rewards from startTime up to endTime
+func fetchRewardsInRange(address string, cache Cache, startTime time.Time, endTime time.Time) ([]Reward, error) {
+	allRewards, err := fetchWalletRewards(address, cache, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var rewards []Reward
 
 	for _, reward := range allRewards {
+		timestamp := time.Time(reward.Timestamp)
+
+		// the api's pages can run past either end of the boundary days
+		if timestamp.Before(startTime) || !timestamp.Before(endTime) {
+			continue
+		}
+
+		rewards = append(rewards, reward)
+	}
+
+	return rewards, nil
+}
+
+func groupRewardsByDay(rewards []Reward) EarningsByToken {
+	earnings := make(EarningsByToken)
+
+	for _, reward := range rewards {
+		token := reward.token()
 		key := dateAtStartOfDay(time.Time(reward.Timestamp))
 
-		if val, ok := earnings[key]; ok {
-			earnings[key] = val + reward.Amount
-		} else {
-			earnings[key] = reward.Amount
+		if _, ok := earnings[token]; !ok {
+			earnings[token] = make(EarningsByDay)
 		}
+
+		if _, ok := earnings[token][key]; !ok {
+			earnings[token][key] = &DayEarnings{ByHotspot: make(map[string]int64)}
+		}
+
+		earnings[token][key].Total += reward.Amount
+		earnings[token][key].ByHotspot[reward.Gateway] += reward.Amount
 	}
 
 	return earnings
 }
 
-func fetchBalance(address string, cache *mc.Client) float64 {
+func fetchBalance(address string, cache Cache) (Decimal, error) {
 	url := fmt.Sprintf("https://api.helium.io/v1/accounts/%s", address)
 
-	response := fetchUrl(url, cache)
-
 	responseObject := AddressResponse{}
 
-	json.Unmarshal(response, &responseObject)
-
-	if responseObject.Data.Balance == 0 {
-		return 0
+	err := fetchJson(url, cache, &responseObject)
+	if err != nil {
+		return Decimal{}, err
 	}
 
-	return float64(responseObject.Data.Balance) / 100000000
+	return NewDecimalFromBaseUnits(responseObject.Data.Balance, HNT_DECIMALS), nil
 }
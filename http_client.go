@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const HTTP_TIMEOUT = 30 * time.Second
+
+// the upstream answered, but not with a 2xx
+type HttpStatusError struct {
+	Url        string
+	StatusCode int
+}
+
+func (e *HttpStatusError) Error() string {
+	return fmt.Sprintf("%s returned %d %s", e.Url, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+type TimeoutError struct {
+	Url string
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out: %s", e.Url, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// the upstream answered with a body we couldn't make sense of
+type DecodeError struct {
+	Url string
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("unable to decode %s: %s", e.Url, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// limited per host and retried with backoff, see rate_limit.go
+var httpClient = newUpstreamHttpClient(HTTP_TIMEOUT)
+
+// the status to answer with when an upstream lets us down
+func upstreamErrorStatus(err error) int {
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return http.StatusGatewayTimeout
+	}
+
+	var statusErr *HttpStatusError
+	var decodeErr *DecodeError
+	if errors.As(err, &statusErr) || errors.As(err, &decodeErr) {
+		return http.StatusBadGateway
+	}
+
+	return http.StatusInternalServerError
+}
+
+// cache hits never touch the network, so they skip the rate limiter too
+func fetchUrl(url string, cache Cache) ([]byte, error) {
+	return fetchCheckedUrl(url, cache, nil)
+}
+
+/*
+ Like fetchUrl, but check sees a fetched body before it's cached. A body
+ it rejects is never cached, and its error is returned.
+*/
+func fetchCheckedUrl(url string, cache Cache, check func([]byte) error) ([]byte, error) {
+	val, cacheReadErr := cache.Get(url)
+	if cacheReadErr == nil {
+		log.Printf("Cache Hit: %s", url)
+		return []byte(val), nil
+	}
+
+	body, err := fetchUrlUncached(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if check != nil {
+		err = check(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// only successful responses make it this far, errors are never cached
+	cacheWriteErr := cache.Set(url, string(body), URL_CACHE_TTL)
+	if cacheWriteErr != nil {
+		log.Printf("Failed to cache %s\n", url)
+		log.Println("Cache write error: ", cacheWriteErr)
+	}
+
+	return body, nil
+}
+
+func fetchUrlUncached(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return doRequest(url, req)
+}
+
+// for the json-rpc apis the solana sdk doesn't cover
+func postJsonUncached(url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	return doRequest(url, req)
+}
+
+func doRequest(url string, req *http.Request) ([]byte, error) {
+	req.Header.Add("User-Agent", "hnt-hmrc")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, &TimeoutError{url, err}
+		}
+
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	log.Printf("%d - %s\n", res.StatusCode, url)
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, &TimeoutError{url, err}
+		}
+
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &HttpStatusError{url, res.StatusCode}
+	}
+
+	return body, nil
+}
+
+// fetches a url and decodes the json body into value
+func fetchJson(url string, cache Cache, value any) error {
+	return fetchValidJson(url, cache, value, nil)
+}
+
+/*
+ Like fetchJson, but valid checks the decoded value before the body is
+ cached, e.g. a rate limited response that is still json but has no
+ prices. A cached body that no longer decodes or passes is dropped.
+*/
+func fetchValidJson(url string, cache Cache, value any, valid func() error) error {
+	decoded := false
+
+	decode := func(body []byte) error {
+		decoded = true
+
+		err := json.Unmarshal(body, value)
+		if err != nil {
+			return &DecodeError{url, err}
+		}
+
+		if valid != nil {
+			return valid()
+		}
+
+		return nil
+	}
+
+	response, err := fetchCheckedUrl(url, cache, decode)
+	if err != nil || decoded {
+		return err
+	}
+
+	err = decode(response)
+	if err != nil {
+		cache.Delete(url)
+	}
+
+	return err
+}
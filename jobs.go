@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+type Job struct {
+	Id        string    `json:"id"`
+	Address   string    `json:"address"`
+	TaxYear   int       `json:"tax_year"`
+	State     JobState  `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (job *Job) isTerminal() bool {
+	return job.State == JobSucceeded || job.State == JobFailed
+}
+
+// a job that stops updating was lost, e.g. the dyno restarted mid-fetch, running jobs save a heartbeat
+func (job *Job) isStale() bool {
+	return !job.isTerminal() && time.Since(job.UpdatedAt) > JOB_STALE_AFTER
+}
+
+func newJobId() string {
+	buf := make([]byte, 8)
+
+	_, err := rand.Read(buf)
+	if err != nil {
+		// crypto/rand should never fail, but fall back to the clock if it does
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+func newJob(address string, taxYear int) *Job {
+	now := time.Now().UTC()
+
+	return &Job{
+		Id:        newJobId(),
+		Address:   address,
+		TaxYear:   taxYear,
+		State:     JobQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func jobCacheKey(id string) string {
+	return fmt.Sprintf("v1-job-%s", id)
+}
+
+// points an address + tax year at the most recent job for it
+func jobRequestCacheKey(address string, taxYear int) string {
+	return fmt.Sprintf("v1-job-%s-%d", address, taxYear)
+}
+
+func saveJob(job *Job, cache Cache) error {
+	job.UpdatedAt = time.Now().UTC()
+
+	jsonData, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	err = cache.Set(jobCacheKey(job.Id), string(jsonData), JOB_CACHE_TTL)
+	if err != nil {
+		return err
+	}
+
+	err = cache.Set(jobRequestCacheKey(job.Address, job.TaxYear), job.Id, JOB_CACHE_TTL)
+
+	return err
+}
+
+func loadJob(id string, cache Cache) (*Job, error) {
+	cachedData, err := cache.Get(jobCacheKey(id))
+	if err != nil {
+		return nil, err
+	}
+
+	job := Job{}
+	err = json.Unmarshal([]byte(cachedData), &job)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.isStale() {
+		log.Printf("Job %s is stale, marking as failed", job.Id)
+		updateJobState(&job, JobFailed, fmt.Errorf("job stopped responding"), cache)
+	}
+
+	return &job, nil
+}
+
+func loadJobForRequest(address string, taxYear int, cache Cache) (*Job, error) {
+	id, err := cache.Get(jobRequestCacheKey(address, taxYear))
+	if err != nil {
+		return nil, err
+	}
+
+	return loadJob(id, cache)
+}
+
+func updateJobState(job *Job, state JobState, jobErr error, cache Cache) {
+	job.State = state
+	job.Error = ""
+
+	if jobErr != nil {
+		job.Error = jobErr.Error()
+	}
+
+	err := saveJob(job, cache)
+	if err != nil {
+		log.Printf("Failed to save job %s (%s) %s", job.Id, job.State, err)
+	}
+}
+
+func runJob(job *Job, cache Cache) {
+	runJobWork(job, cache, JOB_HEARTBEAT_INTERVAL, func() error {
+		return fetchData(job.Address, job.TaxYear, cache)
+	})
+}
+
+/*
+ Saves a copy of the running job every interval, so a long fetch isn't
+ mistaken for a lost one. The returned func stops it, and only returns
+ once the last save is done, so it can't overwrite the job's final state.
+*/
+func startJobHeartbeat(job *Job, cache Cache, interval time.Duration) func() {
+	heartbeat := *job
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := saveJob(&heartbeat, cache)
+				if err != nil {
+					log.Printf("Failed to save job %s heartbeat %s", heartbeat.Id, err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+func runJobWork(job *Job, cache Cache, heartbeatInterval time.Duration, work func() error) {
+	var stopHeartbeat func()
+
+	// a panic inside the fetch would otherwise leave the job running forever
+	defer func() {
+		if r := recover(); r != nil {
+			if stopHeartbeat != nil {
+				stopHeartbeat()
+			}
+
+			log.Printf("Job %s panicked %v", job.Id, r)
+			updateJobState(job, JobFailed, fmt.Errorf("%v", r), cache)
+		}
+	}()
+
+	updateJobState(job, JobRunning, nil, cache)
+
+	stopHeartbeat = startJobHeartbeat(job, cache, heartbeatInterval)
+
+	err := work()
+
+	stopHeartbeat()
+
+	if err != nil {
+		log.Printf("Job %s failed %s", job.Id, err)
+		updateJobState(job, JobFailed, err, cache)
+		return
+	}
+
+	updateJobState(job, JobSucceeded, nil, cache)
+}
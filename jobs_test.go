@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobLifecycle(t *testing.T) {
+	cache := newMemoryCache(10)
+	job := newJob("wallet", 2023)
+
+	if err := saveJob(job, cache); err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	if queued, err := loadJobForRequest("wallet", 2023, cache); err != nil || queued.State != JobQueued {
+		t.Fatalf("Expected a queued job got %+v %s", queued, err)
+	}
+
+	runJobWork(job, cache, time.Hour, func() error {
+		running, err := loadJob(job.Id, cache)
+		if err != nil || running.State != JobRunning {
+			t.Fatalf("Expected a running job got %+v %s", running, err)
+		}
+
+		return nil
+	})
+
+	if done, _ := loadJob(job.Id, cache); done.State != JobSucceeded || done.Error != "" {
+		t.Fatalf("Expected a succeeded job got %+v", done)
+	}
+
+	failed := newJob("wallet", 2022)
+	runJobWork(failed, cache, time.Hour, func() error {
+		return errors.New("upstream is down")
+	})
+
+	if loaded, _ := loadJob(failed.Id, cache); loaded.State != JobFailed || loaded.Error != "upstream is down" {
+		t.Fatalf("Expected a failed job got %+v", loaded)
+	}
+
+	panicked := newJob("wallet", 2021)
+	runJobWork(panicked, cache, time.Hour, func() error {
+		panic("boom")
+	})
+
+	if loaded, _ := loadJob(panicked.Id, cache); loaded.State != JobFailed || loaded.Error != "boom" {
+		t.Fatalf("Expected a failed job got %+v", loaded)
+	}
+}
+
+func TestStaleJob(t *testing.T) {
+	cache := newMemoryCache(10)
+	job := newJob("wallet", 2023)
+	job.State = JobRunning
+
+	// saved before the dyno restarted, nothing has touched it since
+	job.UpdatedAt = time.Now().Add(-JOB_STALE_AFTER - time.Minute)
+	jsonData, _ := json.Marshal(job)
+	cache.Set(jobCacheKey(job.Id), string(jsonData), JOB_CACHE_TTL)
+
+	loaded, err := loadJob(job.Id, cache)
+	if err != nil || loaded.State != JobFailed {
+		t.Fatalf("Expected a stale job to fail got %+v %s", loaded, err)
+	}
+}
+
+func TestJobHeartbeat(t *testing.T) {
+	cache := newMemoryCache(10)
+	job := newJob("wallet", 2023)
+
+	runJobWork(job, cache, 5*time.Millisecond, func() error {
+		started, _ := loadJob(job.Id, cache)
+
+		// a long fetch keeps the job fresh
+		time.Sleep(50 * time.Millisecond)
+
+		running, _ := loadJob(job.Id, cache)
+		if running.State != JobRunning || !running.UpdatedAt.After(started.UpdatedAt) {
+			t.Fatalf("Expected a heartbeat got %+v then %+v", started, running)
+		}
+
+		return nil
+	})
+
+	// the heartbeat has stopped, so it can't overwrite the result
+	time.Sleep(20 * time.Millisecond)
+
+	if done, _ := loadJob(job.Id, cache); done.State != JobSucceeded {
+		t.Fatalf("Expected a succeeded job got %+v", done)
+	}
+}
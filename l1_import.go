@@ -0,0 +1,636 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ A row of the rewards table from a Helium ETL dump, as CSV or JSON. The
+ ETL stores time as unix seconds and amounts in bones, but dumps can
+ quote them, so they're read as strings.
+*/
+type etlReward struct {
+	Block           json.Number     `json:"block"`
+	TransactionHash string          `json:"transaction_hash"`
+	Time            json.RawMessage `json:"time"`
+	Account         string          `json:"account"`
+	Gateway         string          `json:"gateway"`
+	Amount          json.Number     `json:"amount"`
+	Type            string          `json:"type"`
+}
+
+// unix seconds, or the timestamps postgres and the api wrote
+func parseL1Time(value string) (time.Time, error) {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05Z07", "2006-01-02 15:04:05Z07:00", "2006-01-02 15:04:05"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unable to parse time %q", value)
+}
+
+func etlRewardFromFields(block string, hash string, timestamp string, account string, gateway string, amount string, rewardType string) (Reward, error) {
+	parsedTime, err := parseL1Time(timestamp)
+	if err != nil {
+		return Reward{}, err
+	}
+
+	parsedAmount, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return Reward{}, fmt.Errorf("unable to parse amount %q", amount)
+	}
+
+	var parsedBlock int64
+	if block != "" {
+		parsedBlock, err = strconv.ParseInt(block, 10, 64)
+		if err != nil {
+			return Reward{}, fmt.Errorf("unable to parse block %q", block)
+		}
+	}
+
+	if account == "" {
+		return Reward{}, fmt.Errorf("reward at %s has no account", timestamp)
+	}
+
+	return Reward{
+		Account:   account,
+		Gateway:   gateway,
+		Amount:    parsedAmount,
+		Timestamp: RewardTime(parsedTime),
+		Block:     parsedBlock,
+		Hash:      hash,
+		Type:      rewardType,
+		Source:    L1_SOURCE_ETL,
+	}, nil
+}
+
+/*
+ What the readers hand each row to as it's read, so a dump with every
+ account's rewards never has to fit in memory.
+*/
+type l1RowHandler struct {
+	reward   func(Reward) error
+	transfer func(HotspotTransfer) error
+}
+
+func (row etlReward) reward() (Reward, error) {
+	return etlRewardFromFields(row.Block.String(), row.TransactionHash, string(row.Time), row.Account, row.Gateway, row.Amount.String(), row.Type)
+}
+
+// an ETL rewards table as CSV, the columns are found by their header
+func readETLRewardsCSV(r io.Reader, emit func(Reward) error) error {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+
+	columns := make(map[string]int)
+
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	// the api's names for the same columns
+	aliases := map[string]string{"hash": "transaction_hash", "timestamp": "time"}
+	for alias, name := range aliases {
+		if i, ok := columns[alias]; ok {
+			if _, exists := columns[name]; !exists {
+				columns[name] = i
+			}
+		}
+	}
+
+	for _, required := range []string{"time", "account", "gateway", "amount"} {
+		if _, ok := columns[required]; !ok {
+			return fmt.Errorf("the rewards CSV has no %s column", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+
+		return ""
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		reward, err := etlRewardFromFields(
+			field(record, "block"),
+			field(record, "transaction_hash"),
+			field(record, "time"),
+			field(record, "account"),
+			field(record, "gateway"),
+			field(record, "amount"),
+			field(record, "type"))
+		if err == nil {
+			err = emit(reward)
+		}
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+}
+
+/*
+ A row of the transactions table from an ETL dump, the transaction itself
+ is in fields.
+*/
+type etlTransaction struct {
+	Block  json.Number     `json:"block"`
+	Hash   string          `json:"hash"`
+	Type   string          `json:"type"`
+	Fields json.RawMessage `json:"fields"`
+	Time   json.RawMessage `json:"time"`
+}
+
+func isTransferType(transactionType string) bool {
+	return strings.HasPrefix(transactionType, "transfer_hotspot")
+}
+
+func (row etlTransaction) transfer() (HotspotTransfer, error) {
+	var tx l1TransferTransaction
+
+	err := json.Unmarshal(row.Fields, &tx)
+	if err != nil {
+		return HotspotTransfer{}, err
+	}
+
+	tx.Type, tx.Hash, tx.Time = row.Type, row.Hash, row.Time
+
+	if row.Block != "" {
+		tx.Height, err = row.Block.Int64()
+		if err != nil {
+			return HotspotTransfer{}, fmt.Errorf("unable to parse block %q", row.Block)
+		}
+	}
+
+	return tx.transfer()
+}
+
+// the hotspot transfers in an ETL transactions table as CSV, other transactions are skipped
+func readETLTransactionsCSV(r io.Reader, emit func(HotspotTransfer) error) error {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+
+	columns := make(map[string]int)
+
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, required := range []string{"type", "fields", "time"} {
+		if _, ok := columns[required]; !ok {
+			return fmt.Errorf("the transactions CSV has no %s column", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+
+		return ""
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !isTransferType(field(record, "type")) {
+			continue
+		}
+
+		row := etlTransaction{
+			Block:  json.Number(field(record, "block")),
+			Hash:   field(record, "hash"),
+			Type:   field(record, "type"),
+			Fields: json.RawMessage(field(record, "fields")),
+			Time:   json.RawMessage(strconv.Quote(field(record, "time"))),
+		}
+
+		transfer, err := row.transfer()
+		if err == nil {
+			err = emit(transfer)
+		}
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+}
+
+/*
+ JSON holding ETL rows, as an array or one object per line, or archived
+ pages of the api's /hotspots/:address/rewards or /accounts/:address/activity,
+ which wrap them in data. Pages can be concatenated or collected in an
+ array. Rows of the transactions table and activity that aren't hotspot
+ transfers are skipped. Top level arrays and objects are read a value at
+ a time, a page's data is small enough to read whole.
+*/
+func readL1JSON(r io.Reader, handler l1RowHandler) error {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	decoder.UseNumber()
+
+	var decodeFields func(fields map[string]json.RawMessage) error
+
+	decodeValue := func(raw json.RawMessage) error {
+		var fields map[string]json.RawMessage
+
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return err
+		}
+
+		return decodeFields(fields)
+	}
+
+	decodeFields = func(fields map[string]json.RawMessage) error {
+		if data, ok := fields["data"]; ok {
+			var values []json.RawMessage
+			if err := json.Unmarshal(data, &values); err != nil {
+				return err
+			}
+
+			for _, value := range values {
+				if err := decodeValue(value); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		raw, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+
+		var transactionType string
+		json.Unmarshal(fields["type"], &transactionType)
+
+		_, isTransaction := fields["fields"]
+
+		switch {
+		case isTransferType(transactionType) && isTransaction:
+			var row etlTransaction
+			if err := json.Unmarshal(raw, &row); err != nil {
+				return err
+			}
+
+			transfer, err := row.transfer()
+			if err != nil {
+				return err
+			}
+
+			return handler.transfer(transfer)
+
+		case isTransferType(transactionType):
+			var tx l1TransferTransaction
+			if err := json.Unmarshal(raw, &tx); err != nil {
+				return err
+			}
+
+			transfer, err := tx.transfer()
+			if err != nil {
+				return err
+			}
+
+			return handler.transfer(transfer)
+
+		case isTransaction:
+			// some other transaction
+			return nil
+
+		case fields["amount"] == nil:
+			// some other activity
+			return nil
+
+		case fields["timestamp"] != nil:
+			var reward Reward
+			if err := json.Unmarshal(raw, &reward); err != nil {
+				return err
+			}
+
+			reward.Source = L1_SOURCE_API
+
+			return handler.reward(reward)
+		}
+
+		var row etlReward
+		if err := json.Unmarshal(raw, &row); err != nil {
+			return err
+		}
+
+		reward, err := row.reward()
+		if err != nil {
+			return err
+		}
+
+		return handler.reward(reward)
+	}
+
+	// the object whose opening brace has just been read
+	readObject := func() error {
+		fields := make(map[string]json.RawMessage)
+
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err != nil {
+				return err
+			}
+
+			fields[fmt.Sprint(key)] = value
+		}
+
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+
+		return decodeFields(fields)
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch token {
+		case json.Delim('{'):
+			err = readObject()
+
+		case json.Delim('['):
+			for err == nil && decoder.More() {
+				token, err = decoder.Token()
+
+				if err == nil && token != json.Delim('{') {
+					err = fmt.Errorf("expected an object, found %v", token)
+				}
+
+				if err == nil {
+					err = readObject()
+				}
+			}
+
+			if err == nil {
+				_, err = decoder.Token()
+			}
+
+		default:
+			err = fmt.Errorf("expected an object or an array, found %v", token)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// a transactions dump has a fields column, a rewards dump doesn't
+func isTransactionsCSV(reader *bufio.Reader) bool {
+	peeked, _ := reader.Peek(4096)
+
+	header, err := csv.NewReader(bytes.NewReader(peeked)).Read()
+	if err != nil {
+		return false
+	}
+
+	for _, name := range header {
+		if strings.ToLower(strings.TrimSpace(name)) == "fields" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func readL1RewardFile(path string, handler l1RowHandler) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		reader := bufio.NewReader(file)
+
+		if isTransactionsCSV(reader) {
+			err = readETLTransactionsCSV(reader, handler.transfer)
+		} else {
+			err = readETLRewardsCSV(reader, handler.reward)
+		}
+	case ".json", ".jsonl", ".ndjson":
+		err = readL1JSON(file, handler)
+	default:
+		return fmt.Errorf("%s isn't a .csv or .json file", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	return nil
+}
+
+// the files given, with directories expanded to the files in them
+func l1RewardFiles(paths []string) ([]string, error) {
+	var files []string
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// rewards are written to the store this many at a time
+const L1_IMPORT_BATCH_SIZE = 100000
+
+/*
+ go run . import-l1 [-dir dir] [-accounts a,b] files or directories
+
+ Loads L1 rewards and hotspot transfers into the store in -dir, which
+ defaults to L1_REWARDS_DIR. An ETL dump has every account's, -accounts
+ keeps just the ones you need.
+*/
+func runL1Import(args []string) error {
+	flags := flag.NewFlagSet("import-l1", flag.ContinueOnError)
+	dir := flags.String("dir", os.Getenv("L1_REWARDS_DIR"), "the reward store directory")
+	accounts := flags.String("accounts", "", "comma separated accounts to import, all of them when empty")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *dir == "" {
+		return fmt.Errorf("set -dir or L1_REWARDS_DIR to the reward store directory")
+	}
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no files to import")
+	}
+
+	store, err := newL1RewardStore(*dir)
+	if err != nil {
+		return err
+	}
+
+	var wanted []string
+	for _, account := range strings.Split(*accounts, ",") {
+		if account = strings.TrimSpace(account); account != "" {
+			wanted = append(wanted, account)
+		}
+	}
+
+	files, err := l1RewardFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	batch, err := store.beginImport()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range files {
+		var rewards []Reward
+		var transfers []HotspotTransfer
+		read, readTransfers := 0, 0
+
+		// rows are staged in batches, the store's files are only written once everything is read
+		flush := func() error {
+			err := batch.addRewards(rewards)
+			if err == nil {
+				err = batch.addTransfers(transfers)
+			}
+
+			rewards, transfers = rewards[:0], transfers[:0]
+
+			return err
+		}
+
+		handler := l1RowHandler{
+			reward: func(reward Reward) error {
+				if len(wanted) > 0 && !containsString(wanted, reward.Account) {
+					return nil
+				}
+
+				read++
+				rewards = append(rewards, reward)
+
+				if len(rewards) >= L1_IMPORT_BATCH_SIZE {
+					return flush()
+				}
+
+				return nil
+			},
+			transfer: func(transfer HotspotTransfer) error {
+				if len(wanted) > 0 && !containsString(wanted, transfer.From) && !containsString(wanted, transfer.To) {
+					return nil
+				}
+
+				readTransfers++
+				transfers = append(transfers, transfer)
+
+				if len(transfers) >= L1_IMPORT_BATCH_SIZE {
+					return flush()
+				}
+
+				return nil
+			},
+		}
+
+		err := readL1RewardFile(path, handler)
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			batch.abort()
+			return err
+		}
+
+		log.Printf("Read %d rewards and %d hotspot transfers from %s", read, readTransfers, path)
+	}
+
+	added, addedTransfers, err := batch.finish()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Imported %d new rewards and %d new hotspot transfers", added, addedTransfers)
+
+	return nil
+}
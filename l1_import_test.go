@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the readers hand over rows as they go, these collect them
+func readL1JSONRows(r io.Reader) ([]Reward, []HotspotTransfer, error) {
+	var rewards []Reward
+	var transfers []HotspotTransfer
+
+	err := readL1JSON(r, l1RowHandler{
+		reward: func(reward Reward) error {
+			rewards = append(rewards, reward)
+			return nil
+		},
+		transfer: func(transfer HotspotTransfer) error {
+			transfers = append(transfers, transfer)
+			return nil
+		},
+	})
+
+	return rewards, transfers, err
+}
+
+func readETLRewardsCSVRows(r io.Reader) ([]Reward, error) {
+	var rewards []Reward
+
+	err := readETLRewardsCSV(r, func(reward Reward) error {
+		rewards = append(rewards, reward)
+		return nil
+	})
+
+	return rewards, err
+}
+
+func readETLTransactionsCSVRows(r io.Reader) ([]HotspotTransfer, error) {
+	var transfers []HotspotTransfer
+
+	err := readETLTransactionsCSV(r, func(transfer HotspotTransfer) error {
+		transfers = append(transfers, transfer)
+		return nil
+	})
+
+	return transfers, err
+}
+
+func TestReadETLRewardsCSV(t *testing.T) {
+	csv := "block,transaction_hash,time,account,gateway,amount,type\n" +
+		"1000,hash-a,1620000000,wallet,hotspot-a,150000000,poc_witnesses\n" +
+		"1001,hash-b,\"2021-05-03 10:00:00+00\",wallet,hotspot-b,25000000,poc_challengees\n"
+
+	rewards, err := readETLRewardsCSVRows(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	if len(rewards) != 2 || rewards[0].Gateway != "hotspot-a" || rewards[0].Amount != 150000000 || rewards[0].Block != 1000 || rewards[0].Type != "poc_witnesses" {
+		t.Fatalf("Unexpected rewards %+v", rewards)
+	}
+
+	if !time.Time(rewards[0].Timestamp).Equal(time.Unix(1620000000, 0)) || time.Time(rewards[1].Timestamp).Format(time.RFC3339) != "2021-05-03T10:00:00Z" {
+		t.Fatalf("Unexpected timestamps %+v", rewards)
+	}
+
+	if _, err := readETLRewardsCSVRows(strings.NewReader("time,account\n1,wallet\n")); err == nil {
+		t.Fatalf("Expected missing columns")
+	}
+}
+
+func TestReadL1JSON(t *testing.T) {
+	// archived api pages, one after the other
+	pages := `{"data": [{"account": "wallet", "gateway": "hotspot-a", "amount": 100, "block": 5, "hash": "hash-a", "timestamp": "2021-05-01T10:00:00.000000Z"}], "cursor": "next"}
+{"data": [{"account": "wallet", "gateway": "hotspot-a", "amount": 200, "block": 6, "hash": "hash-b", "timestamp": "2021-05-02T10:00:00.000000Z"}]}`
+
+	rewards, _, err := readL1JSONRows(strings.NewReader(pages))
+	if err != nil || len(rewards) != 2 || rewards[1].Amount != 200 || rewards[1].Hash != "hash-b" {
+		t.Fatalf("Unexpected rewards %+v %s", rewards, err)
+	}
+
+	// ETL rows, with the numbers quoted
+	rows := `[{"block": "7", "transaction_hash": "hash-c", "time": "1620000000", "account": "wallet", "gateway": "hotspot-b", "amount": "300", "type": "securities"}]`
+
+	rewards, _, err = readL1JSONRows(strings.NewReader(rows))
+	if err != nil || len(rewards) != 1 || rewards[0].Amount != 300 || rewards[0].Block != 7 || !time.Time(rewards[0].Timestamp).Equal(time.Unix(1620000000, 0)) {
+		t.Fatalf("Unexpected rewards %+v %s", rewards, err)
+	}
+
+	if rewards[0].Source != L1_SOURCE_ETL {
+		t.Fatalf("Unexpected source %s", rewards[0].Source)
+	}
+
+	// a top level array is handed over a row at a time, so a failing handler stops it at the first
+	stop := errors.New("stop")
+	read := 0
+
+	err = readL1JSON(strings.NewReader(`[`+strings.Repeat(`{"time": 1620000000, "account": "wallet", "gateway": "hotspot-b", "amount": 1},`, 3)+`{"bad": `), l1RowHandler{
+		reward: func(reward Reward) error {
+			read++
+			return stop
+		},
+	})
+	if err != stop || read != 1 {
+		t.Fatalf("Expected the handler's error after one row got %d %v", read, err)
+	}
+}
+
+func TestReadL1Transfers(t *testing.T) {
+	// an archived activity page, then ETL transactions rows
+	values := `{"data": [
+		{"type": "transfer_hotspot_v1", "hash": "hash-a", "time": 1620000000, "gateway": "hotspot-a", "seller": "wallet", "buyer": "buyer"},
+		{"type": "payment_v1", "hash": "hash-b", "time": 1620000001, "payer": "wallet"}
+	]}
+{"block": 10, "hash": "hash-c", "type": "transfer_hotspot_v2", "time": 1620000002, "fields": {"gateway": "hotspot-b", "owner": "seller", "new_owner": "wallet"}}
+{"block": 11, "hash": "hash-d", "type": "payment_v2", "time": 1620000003, "fields": {"payer": "wallet"}}`
+
+	rewards, transfers, err := readL1JSONRows(strings.NewReader(values))
+	if err != nil || len(rewards) != 0 || len(transfers) != 2 {
+		t.Fatalf("Unexpected %+v %+v %s", rewards, transfers, err)
+	}
+
+	if transfers[0] != (HotspotTransfer{Hotspot: "hotspot-a", From: "wallet", To: "buyer", Timestamp: RewardTime(time.Unix(1620000000, 0).UTC()), Hash: "hash-a"}) {
+		t.Fatalf("Unexpected v1 transfer %+v", transfers[0])
+	}
+
+	if transfers[1].Hotspot != "hotspot-b" || transfers[1].From != "seller" || transfers[1].To != "wallet" || transfers[1].Hash != "hash-c" {
+		t.Fatalf("Unexpected v2 transfer %+v", transfers[1])
+	}
+
+	csv := "block,hash,type,fields,time\n" +
+		"10,hash-c,transfer_hotspot_v2,\"{\"\"gateway\"\": \"\"hotspot-b\"\", \"\"owner\"\": \"\"seller\"\", \"\"new_owner\"\": \"\"wallet\"\"}\",1620000002\n" +
+		"11,hash-d,payment_v2,\"{\"\"payer\"\": \"\"wallet\"\"}\",1620000003\n"
+
+	if !isTransactionsCSV(bufio.NewReader(strings.NewReader(csv))) {
+		t.Fatalf("Expected a transactions CSV")
+	}
+
+	transfers, err = readETLTransactionsCSVRows(strings.NewReader(csv))
+	if err != nil || len(transfers) != 1 || transfers[0].To != "wallet" || !time.Time(transfers[0].Timestamp).Equal(time.Unix(1620000002, 0)) {
+		t.Fatalf("Unexpected transfers %+v %s", transfers, err)
+	}
+}
+
+func TestL1RewardStore(t *testing.T) {
+	store, err := newL1RewardStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	day := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	rewards := []Reward{
+		{Account: "wallet", Gateway: "hotspot-a", Amount: 200, Timestamp: RewardTime(day.AddDate(0, 0, 1)), Hash: "hash-b"},
+		{Account: "wallet", Gateway: "hotspot-a", Amount: 100, Timestamp: RewardTime(day), Hash: "hash-a"},
+		{Account: "other", Gateway: "hotspot-c", Amount: 50, Timestamp: RewardTime(day), Hash: "hash-c"},
+	}
+
+	added, err := store.Import(rewards)
+	if err != nil || added != 3 {
+		t.Fatalf("Expected 3 new rewards got %d %s", added, err)
+	}
+
+	// importing an overlapping dump only adds what's new
+	added, err = store.Import(append(rewards, Reward{Account: "wallet", Amount: 300, Timestamp: RewardTime(day.AddDate(0, 0, 2)), Hash: "hash-d"}))
+	if err != nil || added != 1 {
+		t.Fatalf("Expected 1 new reward got %d %s", added, err)
+	}
+
+	stored, _ := store.Rewards("wallet")
+	if len(stored) != 3 || stored[0].Hash != "hash-a" || stored[2].Hash != "hash-d" {
+		t.Fatalf("Unexpected stored rewards %+v", stored)
+	}
+
+	inRange, _ := store.RewardsInRange("wallet", day, day.AddDate(0, 0, 2))
+	if len(inRange) != 2 {
+		t.Fatalf("Unexpected rewards in range %+v", inRange)
+	}
+
+	if none, err := store.Rewards("nobody"); err != nil || none != nil {
+		t.Fatalf("Expected no rewards %+v %s", none, err)
+	}
+
+	if _, err := store.Rewards("../escape"); err == nil {
+		t.Fatalf("Expected a bad account")
+	}
+
+	// the same rewards from the api's pages don't match the ETL's, so they can't be mixed
+	added, err = store.Import([]Reward{{Account: "etl", Amount: 1, Timestamp: RewardTime(day), Source: L1_SOURCE_ETL}})
+	if err != nil || added != 1 {
+		t.Fatalf("Expected 1 new reward got %d %s", added, err)
+	}
+
+	if _, err := store.Import([]Reward{{Account: "etl", Amount: 1, Timestamp: RewardTime(day), Source: L1_SOURCE_API}}); err == nil {
+		t.Fatalf("Expected api rewards to be refused")
+	}
+
+	transfer := HotspotTransfer{Hotspot: "hotspot-a", From: "wallet", To: "buyer", Timestamp: RewardTime(day), Hash: "hash-t"}
+
+	added, err = store.ImportTransfers([]HotspotTransfer{transfer, transfer})
+	if err != nil || added != 1 {
+		t.Fatalf("Expected 1 new transfer got %d %s", added, err)
+	}
+
+	// kept with both sides of the transfer
+	for _, account := range []string{"wallet", "buyer"} {
+		if transfers, _ := store.TransfersInRange(account, day, day.AddDate(0, 0, 1)); len(transfers) != 1 || transfers[0] != transfer {
+			t.Fatalf("Unexpected transfers for %s %+v", account, transfers)
+		}
+	}
+
+	if transfers, _ := store.TransfersInRange("wallet", day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)); len(transfers) != 0 {
+		t.Fatalf("Unexpected transfers in range %+v", transfers)
+	}
+}
+
+func TestL1ImportBatch(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := newL1RewardStore(dir)
+	day := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	batch, err := store.beginImport()
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	// the batches are only staged, the account's file isn't touched until the import finishes
+	for i := 0; i < 3; i++ {
+		err = batch.addRewards([]Reward{{Account: "wallet", Amount: int64(i + 1), Timestamp: RewardTime(day.AddDate(0, 0, -i)), Hash: fmt.Sprintf("hash-%d", i)}})
+		if err != nil {
+			t.Fatalf("Failure %s", err)
+		}
+	}
+
+	err = batch.addTransfers([]HotspotTransfer{{Hotspot: "hotspot-a", From: "wallet", To: "buyer", Timestamp: RewardTime(day)}})
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "wallet.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected nothing written before the import finishes %s", err)
+	}
+
+	added, addedTransfers, err := batch.finish()
+	if err != nil || added != 3 || addedTransfers != 1 {
+		t.Fatalf("Unexpected import %d %d %s", added, addedTransfers, err)
+	}
+
+	// sorted once at the end
+	stored, _ := store.Rewards("wallet")
+	if len(stored) != 3 || stored[0].Hash != "hash-2" || stored[2].Hash != "hash-0" {
+		t.Fatalf("Unexpected stored rewards %+v", stored)
+	}
+
+	// and the staging files are gone
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Fatalf("Unexpected files left in the store %v", entries)
+	}
+
+	if err := batch.addRewards([]Reward{{Account: "../escape"}}); err == nil {
+		t.Fatalf("Expected a bad account")
+	}
+}
+
+func TestRunL1Import(t *testing.T) {
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "rewards.csv")
+
+	os.WriteFile(dump, []byte("time,account,gateway,amount\n1620000000,wallet,hotspot-a,100\n1620000000,other,hotspot-b,200\n"), 0o644)
+
+	storeDir := filepath.Join(dir, "store")
+
+	err := runL1Import([]string{"-dir", storeDir, "-accounts", "wallet", dump})
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	store, _ := newL1RewardStore(storeDir)
+
+	if rewards, _ := store.Rewards("wallet"); len(rewards) != 1 {
+		t.Fatalf("Unexpected rewards %+v", rewards)
+	}
+
+	if rewards, _ := store.Rewards("other"); len(rewards) != 0 {
+		t.Fatalf("Only the accounts asked for should be imported %+v", rewards)
+	}
+
+	activity := filepath.Join(dir, "activity.json")
+
+	os.WriteFile(activity, []byte(`{"data": [
+		{"type": "transfer_hotspot_v1", "hash": "hash-a", "time": 1620000000, "gateway": "hotspot-a", "seller": "wallet", "buyer": "buyer"},
+		{"type": "transfer_hotspot_v1", "hash": "hash-b", "time": 1620000000, "gateway": "hotspot-b", "seller": "other", "buyer": "buyer"}
+	]}`), 0o644)
+
+	err = runL1Import([]string{"-dir", storeDir, "-accounts", "wallet", activity})
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	if transfers, _ := store.Transfers("wallet"); len(transfers) != 1 || transfers[0].Hash != "hash-a" {
+		t.Fatalf("Unexpected transfers %+v", transfers)
+	}
+
+	if transfers, _ := store.Transfers("buyer"); len(transfers) != 1 {
+		t.Fatalf("Only transfers involving the accounts asked for should be imported %+v", transfers)
+	}
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ The L1 api is gone, so rewards from before the migration are imported
+ from local files (see l1_import.go) into a directory with a JSON file of
+ rewards per account, and another of the hotspots it sent or received.
+ When L1_REWARDS_DIR is set they're read from there in place of the api.
+*/
+type L1RewardStore struct {
+	dir string
+}
+
+// nil when the L1 rewards come from the api
+var l1RewardStore *L1RewardStore
+
+/*
+ The ETL has a row per reward type where the api summed them, with
+ different hashes, so the same rewards from both can't be matched up.
+ An account's rewards all have to come from one or the other.
+*/
+const L1_SOURCE_ETL = "etl"
+const L1_SOURCE_API = "api"
+
+func newL1RewardStore(dir string) (*L1RewardStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &L1RewardStore{dir}, nil
+}
+
+func (store *L1RewardStore) path(account string, suffix string) (string, error) {
+	// account addresses are base58, anything else could escape the directory
+	if account == "" || strings.ContainsAny(account, `/\.`) {
+		return "", fmt.Errorf("%q is not an account address", account)
+	}
+
+	return filepath.Join(store.dir, account+suffix), nil
+}
+
+// false when nothing has been imported into the file yet
+func (store *L1RewardStore) load(path string, value any) (bool, error) {
+	contents, err := os.ReadFile(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(contents, value)
+}
+
+// files are replaced whole so a failed import never leaves one half written
+func (store *L1RewardStore) save(path string, value any) error {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(store.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = temp.Write(jsonData)
+	closeErr := temp.Close()
+
+	if err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+
+	return err
+}
+
+// the account's rewards, oldest first
+func (store *L1RewardStore) Rewards(account string) ([]Reward, error) {
+	path, err := store.path(account, ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	var rewards []Reward
+
+	found, err := store.load(path, &rewards)
+	if !found && err == nil {
+		log.Printf("No L1 rewards have been imported for %s", account)
+	}
+
+	return rewards, err
+}
+
+func (store *L1RewardStore) RewardsInRange(account string, startTime time.Time, endTime time.Time) ([]Reward, error) {
+	rewards, err := store.Rewards(account)
+	if err != nil {
+		return nil, err
+	}
+
+	var inRange []Reward
+
+	for _, reward := range rewards {
+		timestamp := time.Time(reward.Timestamp)
+
+		if !timestamp.Before(startTime) && timestamp.Before(endTime) {
+			inRange = append(inRange, reward)
+		}
+	}
+
+	return inRange, nil
+}
+
+func sourceName(source string) string {
+	if source == L1_SOURCE_API {
+		return "api's pages"
+	}
+
+	return "ETL"
+}
+
+// the same reward imported twice, e.g. from overlapping dumps, has the same key
+func rewardKey(reward Reward) string {
+	return fmt.Sprintf("%s|%s|%s|%d|%d|%d|%s",
+		reward.Hash, reward.Gateway, reward.Type, reward.Block, reward.Amount,
+		time.Time(reward.Timestamp).UnixNano(), reward.token())
+}
+
+/*
+ Merges the rewards into each account's file, skipping any already there,
+ and returns how many were new.
+*/
+func (store *L1RewardStore) Import(rewards []Reward) (int, error) {
+	batch, err := store.beginImport()
+	if err != nil {
+		return 0, err
+	}
+
+	err = batch.addRewards(rewards)
+	if err != nil {
+		batch.abort()
+		return 0, err
+	}
+
+	added, _, err := batch.finish()
+
+	return added, err
+}
+
+// the rewards imported for the account, merged into what it already has
+func (store *L1RewardStore) mergeRewards(account string, imported []Reward) (int, error) {
+	path, err := store.path(account, ".json")
+	if err != nil {
+		return 0, err
+	}
+
+	existing, err := store.Rewards(account)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[string]bool)
+	source := ""
+
+	for _, reward := range existing {
+		seen[rewardKey(reward)] = true
+
+		if source == "" {
+			source = reward.Source
+		}
+	}
+
+	merged := existing
+	added := 0
+
+	for _, reward := range imported {
+		if source == "" {
+			source = reward.Source
+		}
+
+		if reward.Source != "" && reward.Source != source {
+			return 0, fmt.Errorf("%s has rewards imported from the %s, mixing in rewards from the %s would count them twice", account, sourceName(source), sourceName(reward.Source))
+		}
+
+		reward.Timestamp = RewardTime(time.Time(reward.Timestamp).UTC())
+
+		if key := rewardKey(reward); !seen[key] {
+			seen[key] = true
+			merged = append(merged, reward)
+			added++
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return time.Time(merged[i].Timestamp).Before(time.Time(merged[j].Timestamp))
+	})
+
+	return added, store.save(path, merged)
+}
+
+// the hotspots transferred to or from the account, oldest first
+func (store *L1RewardStore) Transfers(account string) ([]HotspotTransfer, error) {
+	path, err := store.path(account, ".transfers.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var transfers []HotspotTransfer
+
+	_, err = store.load(path, &transfers)
+
+	return transfers, err
+}
+
+func (store *L1RewardStore) TransfersInRange(account string, startTime time.Time, endTime time.Time) ([]HotspotTransfer, error) {
+	transfers, err := store.Transfers(account)
+	if err != nil {
+		return nil, err
+	}
+
+	var inRange []HotspotTransfer
+
+	for _, transfer := range transfers {
+		timestamp := time.Time(transfer.Timestamp)
+
+		if !timestamp.Before(startTime) && timestamp.Before(endTime) {
+			inRange = append(inRange, transfer)
+		}
+	}
+
+	return inRange, nil
+}
+
+func transferKey(transfer HotspotTransfer) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d",
+		transfer.Hash, transfer.Hotspot, transfer.From, transfer.To,
+		time.Time(transfer.Timestamp).UnixNano())
+}
+
+/*
+ Like Import, but each transfer is kept with both the account that sent
+ the hotspot and the one that received it. Returns how many transfers
+ were new to either of them.
+*/
+func (store *L1RewardStore) ImportTransfers(transfers []HotspotTransfer) (int, error) {
+	batch, err := store.beginImport()
+	if err != nil {
+		return 0, err
+	}
+
+	err = batch.addTransfers(transfers)
+	if err != nil {
+		batch.abort()
+		return 0, err
+	}
+
+	_, added, err := batch.finish()
+
+	return added, err
+}
+
+// the transfers imported for the account, merged into what it already has, added collects the new ones
+func (store *L1RewardStore) mergeTransfers(account string, imported []HotspotTransfer, added map[string]bool) error {
+	path, err := store.path(account, ".transfers.json")
+	if err != nil {
+		return err
+	}
+
+	existing, err := store.Transfers(account)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+
+	for _, transfer := range existing {
+		seen[transferKey(transfer)] = true
+	}
+
+	merged := existing
+
+	for _, transfer := range imported {
+		transfer.Timestamp = RewardTime(time.Time(transfer.Timestamp).UTC())
+
+		if key := transferKey(transfer); !seen[key] {
+			seen[key] = true
+			added[key] = true
+			merged = append(merged, transfer)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return time.Time(merged[i].Timestamp).Before(time.Time(merged[j].Timestamp))
+	})
+
+	return store.save(path, merged)
+}
+
+/*
+ An import in progress. Rows are appended to a staging file per account
+ as they're read, and each account's file in the store is only merged
+ and rewritten once, by finish, however many batches the rows came in.
+*/
+type l1ImportBatch struct {
+	store *L1RewardStore
+	dir   string
+	// the staging files written, named by the account and the suffix of its file in the store
+	staged map[string]bool
+}
+
+func (store *L1RewardStore) beginImport() (*l1ImportBatch, error) {
+	dir, err := os.MkdirTemp(store.dir, "import-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &l1ImportBatch{store, dir, make(map[string]bool)}, nil
+}
+
+// appends the rows to the account's staging file for the suffix
+func (batch *l1ImportBatch) stage(account string, suffix string, rows []any) error {
+	// checks the account is safe to use as a file name
+	_, err := batch.store.path(account, suffix)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(batch.dir, account+suffix), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+
+	for _, row := range rows {
+		err = encoder.Encode(row)
+		if err != nil {
+			break
+		}
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	batch.staged[account+suffix] = true
+
+	return err
+}
+
+func (batch *l1ImportBatch) addRewards(rewards []Reward) error {
+	byAccount := make(map[string][]any)
+
+	for _, reward := range rewards {
+		byAccount[reward.Account] = append(byAccount[reward.Account], reward)
+	}
+
+	for account, rows := range byAccount {
+		err := batch.stage(account, ".json", rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (batch *l1ImportBatch) addTransfers(transfers []HotspotTransfer) error {
+	byAccount := make(map[string][]any)
+
+	for _, transfer := range transfers {
+		for _, account := range []string{transfer.From, transfer.To} {
+			if account != "" {
+				byAccount[account] = append(byAccount[account], transfer)
+			}
+		}
+	}
+
+	for account, rows := range byAccount {
+		err := batch.stage(account, ".transfers.json", rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reads back a staging file a row at a time
+func readStaged[T any](path string) ([]T, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rows []T
+	decoder := json.NewDecoder(file)
+
+	for decoder.More() {
+		var row T
+
+		err = decoder.Decode(&row)
+		if err != nil {
+			return nil, err
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+/*
+ Merges what was staged into the store, an account at a time, and
+ returns how many rewards and transfers were new.
+*/
+func (batch *l1ImportBatch) finish() (int, int, error) {
+	defer batch.abort()
+
+	var names []string
+	for name := range batch.staged {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	addedRewards := 0
+	addedTransfers := make(map[string]bool)
+
+	for _, name := range names {
+		path := filepath.Join(batch.dir, name)
+
+		if account, ok := strings.CutSuffix(name, ".transfers.json"); ok {
+			transfers, err := readStaged[HotspotTransfer](path)
+			if err == nil {
+				err = batch.store.mergeTransfers(account, transfers, addedTransfers)
+			}
+			if err != nil {
+				return addedRewards, len(addedTransfers), err
+			}
+
+			continue
+		}
+
+		rewards, err := readStaged[Reward](path)
+		if err != nil {
+			return addedRewards, len(addedTransfers), err
+		}
+
+		added, err := batch.store.mergeRewards(strings.TrimSuffix(name, ".json"), rewards)
+		addedRewards += added
+		if err != nil {
+			return addedRewards, len(addedTransfers), err
+		}
+	}
+
+	return addedRewards, len(addedTransfers), nil
+}
+
+// drops whatever was staged
+func (batch *l1ImportBatch) abort() {
+	err := os.RemoveAll(batch.dir)
+	if err != nil {
+		log.Printf("Unable to remove %s %s", batch.dir, err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type LedgerSyntax string
+
+const (
+	LedgerBeancount LedgerSyntax = "beancount"
+	LedgerHledger   LedgerSyntax = "hledger"
+)
+
+// keyed by the extension on /report/:address
+var ledgerSyntaxByExtension = map[string]LedgerSyntax{
+	"beancount": LedgerBeancount,
+	"journal":   LedgerHledger,
+}
+
+// Assets:Crypto:HNT, Assets:Crypto:IOT and so on
+func ledgerAssetAccount(token string) string {
+	return "Assets:Crypto:" + strings.ToUpper(token)
+}
+
+/*
+ Income:Helium:<hotspot>, the hotspot made safe for beancount, whose
+ account components start with a capital letter or digit and only hold
+ letters, digits and dashes.
+*/
+func ledgerIncomeAccount(hotspot string) string {
+	if hotspot == "" {
+		hotspot = UNATTRIBUTED_HOTSPOT
+	}
+
+	var name strings.Builder
+
+	for _, r := range hotspot {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			name.WriteRune(r)
+		default:
+			name.WriteRune('-')
+		}
+	}
+
+	component := strings.Trim(name.String(), "-")
+	if component == "" {
+		component = "Unknown"
+	}
+
+	return "Income:Helium:" + strings.ToUpper(component[:1]) + component[1:]
+}
+
+/*
+ The income account of each hotspot. Making a name safe can give two
+ hotspots the same account, like addresses that only differ in the case
+ of their first letter, so the later one in sorted order gets a number.
+*/
+func ledgerIncomeAccounts(incomes []RewardIncome) map[string]string {
+	var hotspots []string
+
+	for _, income := range incomes {
+		if !containsString(hotspots, income.Hotspot) {
+			hotspots = append(hotspots, income.Hotspot)
+		}
+	}
+
+	sort.Strings(hotspots)
+
+	accounts := make(map[string]string)
+	taken := make(map[string]bool)
+
+	for _, hotspot := range hotspots {
+		account := ledgerIncomeAccount(hotspot)
+
+		for n := 2; taken[account]; n++ {
+			account = fmt.Sprintf("%s-%d", ledgerIncomeAccount(hotspot), n)
+		}
+
+		accounts[hotspot] = account
+		taken[account] = true
+	}
+
+	return accounts
+}
+
+/*
+ A transaction for every reward, tokens into Assets:Crypto:<token> from
+ the hotspot's income account with the GBP value as a total price, and a
+ price directive for every day of the tax year we have a token's price
+ for. Accounts are opened at the start of the tax year so both check
+ tools accept the journal in their strict modes.
+*/
+func writeLedger(w io.Writer, syntax LedgerSyntax, address string, taxYear int, rewards []Reward, data []DataPoint, pricesByToken map[string]PriceQuotesByTime) error {
+	out := bufio.NewWriter(w)
+	start, end := taxYearBounds(taxYear)
+	opened := start.Format("2006-01-02")
+	incomes := rewardIncomes(rewards, data)
+	accountByHotspot := ledgerIncomeAccounts(incomes)
+
+	var tokens, assetAccounts, incomeAccounts []string
+
+	for _, token := range rewardTokens {
+		if _, ok := pricesByToken[token]; ok {
+			tokens = append(tokens, token)
+		}
+	}
+
+	for _, income := range incomes {
+		if !containsString(tokens, income.Token) {
+			tokens = append(tokens, income.Token)
+		}
+	}
+
+	for _, account := range accountByHotspot {
+		incomeAccounts = append(incomeAccounts, account)
+	}
+
+	for _, token := range tokens {
+		assetAccounts = append(assetAccounts, ledgerAssetAccount(token))
+	}
+
+	sort.Strings(incomeAccounts)
+	accounts := append(assetAccounts, incomeAccounts...)
+
+	fmt.Fprintf(out, "; Helium rewards for %s, tax year %d/%d\n\n", address, taxYear, taxYear+1)
+
+	switch syntax {
+	case LedgerBeancount:
+		fmt.Fprintf(out, "option \"operating_currency\" \"GBP\"\n\n")
+		for _, token := range tokens {
+			fmt.Fprintf(out, "%s commodity %s\n", opened, strings.ToUpper(token))
+		}
+		fmt.Fprintf(out, "%s commodity GBP\n", opened)
+		for _, account := range accounts {
+			fmt.Fprintf(out, "%s open %s\n", opened, account)
+		}
+	case LedgerHledger:
+		for _, token := range tokens {
+			fmt.Fprintf(out, "commodity 1.%s %s\n", strings.Repeat("0", int(rewardTokenDecimals[token])), strings.ToUpper(token))
+		}
+		fmt.Fprintf(out, "commodity 1.00 GBP\n")
+		for _, account := range accounts {
+			fmt.Fprintf(out, "account %s\n", account)
+		}
+	default:
+		return fmt.Errorf("unknown ledger syntax %s", syntax)
+	}
+
+	for _, token := range tokens {
+		prices := pricesByToken[token]
+		symbol := strings.ToUpper(token)
+
+		out.WriteString("\n")
+
+		for _, day := range daysInRange(start, end) {
+			quote, ok := prices[day]
+			if !ok || quote.Status == PriceMissing {
+				continue
+			}
+
+			if syntax == LedgerBeancount {
+				fmt.Fprintf(out, "%s price %s %s GBP\n", day.Format("2006-01-02"), symbol, quote.Price)
+			} else {
+				fmt.Fprintf(out, "P %s %s %s GBP\n", day.Format("2006-01-02"), symbol, quote.Price)
+			}
+		}
+	}
+
+	for _, income := range incomes {
+		date := dateAtStartOfDay(income.Timestamp).Format("2006-01-02")
+		timestamp := income.Timestamp.UTC().Format(time.RFC3339)
+		value := income.Value.RoundGBP()
+
+		out.WriteString("\n")
+
+		if syntax == LedgerBeancount {
+			fmt.Fprintf(out, "%s * \"Helium\" %q\n", date, rewardDescription(income))
+			fmt.Fprintf(out, "  timestamp: %q\n", timestamp)
+		} else {
+			fmt.Fprintf(out, "%s Helium | %s  ; timestamp:%s\n", date, rewardDescription(income), timestamp)
+		}
+
+		fmt.Fprintf(out, "  %s  %s %s @@ %s GBP\n", ledgerAssetAccount(income.Token), income.Tokens, strings.ToUpper(income.Token), value)
+		fmt.Fprintf(out, "  %s  %s GBP\n", accountByHotspot[income.Hotspot], value.Neg())
+	}
+
+	return out.Flush()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteLedger(t *testing.T) {
+	timestamp, _ := time.Parse(time.RFC3339, "2023-05-05T10:00:00Z")
+	rewards := []Reward{{Gateway: "angry purple.tiger", Amount: 150000000, Timestamp: RewardTime(timestamp)}}
+	rewards = append(rewards, Reward{Gateway: "angry purple.tiger", Amount: 2000000, Timestamp: RewardTime(timestamp), Token: "iot"})
+	data := []DataPoint{{Date: "2023-05-05", Token: "hnt", Price: d("3.333")}, {Date: "2023-05-05", Token: "iot", Price: d("0.25")}}
+
+	day := dateAtStartOfDay(timestamp)
+	prices := map[string]PriceQuotesByTime{
+		"hnt": {
+			day:                   {d("3.333"), "coingecko", PriceExact},
+			day.AddDate(0, 0, 1):  {d("3.5"), "coingecko", PriceInterpolated},
+			day.AddDate(0, 0, -1): {Status: PriceMissing},
+		},
+		"iot": {
+			day: {d("0.25"), "coingecko", PriceExact},
+		},
+	}
+
+	var buf bytes.Buffer
+	err := writeLedger(&buf, LedgerBeancount, "wallet", 2023, rewards, data, prices)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	beancount := buf.String()
+
+	for _, expected := range []string{
+		"2023-04-06 open Income:Helium:Angry-purple-tiger\n",
+		"2023-04-06 commodity IOT\n",
+		"2023-04-06 open Assets:Crypto:IOT\n",
+		"2023-05-05 price HNT 3.333 GBP\n2023-05-06 price HNT 3.5 GBP\n",
+		"2023-05-05 price IOT 0.25 GBP\n",
+		"2023-05-05 * \"Helium\" \"Helium hotspot reward from angry purple.tiger\"\n  timestamp: \"2023-05-05T10:00:00Z\"\n",
+		"  Assets:Crypto:HNT  1.5 HNT @@ 5.00 GBP\n  Income:Helium:Angry-purple-tiger  -5.00 GBP\n",
+		"  Assets:Crypto:IOT  2 IOT @@ 0.50 GBP\n",
+	} {
+		if !strings.Contains(beancount, expected) {
+			t.Fatalf("Expected %q in\n%s", expected, beancount)
+		}
+	}
+
+	if strings.Contains(beancount, "2023-05-04 price") {
+		t.Fatalf("Missing prices shouldn't be written")
+	}
+
+	buf.Reset()
+	writeLedger(&buf, LedgerHledger, "wallet", 2023, rewards, data, prices)
+	hledger := buf.String()
+
+	for _, expected := range []string{
+		"account Income:Helium:Angry-purple-tiger\n",
+		"commodity 1.000000 IOT\n",
+		"P 2023-05-05 HNT 3.333 GBP\n",
+		"2023-05-05 Helium | Helium hotspot reward from angry purple.tiger  ; timestamp:2023-05-05T10:00:00Z\n",
+		"  Assets:Crypto:HNT  1.5 HNT @@ 5.00 GBP\n",
+	} {
+		if !strings.Contains(hledger, expected) {
+			t.Fatalf("Expected %q in\n%s", expected, hledger)
+		}
+	}
+}
+
+func TestLedgerIncomeAccount(t *testing.T) {
+	if ledgerIncomeAccount("") != "Income:Helium:Unattributed" || ledgerIncomeAccount("11abc") != "Income:Helium:11abc" {
+		t.Fatalf("Unexpected account names")
+	}
+
+	// both are made safe as the same name, but each needs an account of its own
+	accounts := ledgerIncomeAccounts([]RewardIncome{{Hotspot: "a1b"}, {Hotspot: "A1b"}, {Hotspot: "a1b"}, {Hotspot: ""}})
+
+	if len(accounts) != 3 || accounts["A1b"] != "Income:Helium:A1b" || accounts["a1b"] != "Income:Helium:A1b-2" || accounts[""] != "Income:Helium:Unattributed" {
+		t.Fatalf("Unexpected accounts %v", accounts)
+	}
+}
+
+// the journals are meant to pass each tool's own strict checks, run them where they're installed
+func TestLedgerPassesChecks(t *testing.T) {
+	timestamp, _ := time.Parse(time.RFC3339, "2023-05-05T10:00:00Z")
+	rewards := []Reward{
+		{Gateway: "a1b", Amount: 150000000, Timestamp: RewardTime(timestamp), Hash: "tx-a"},
+		{Gateway: "A1b", Amount: 50000000, Timestamp: RewardTime(timestamp), Hash: "tx-a"},
+		{Amount: 2000000, Timestamp: RewardTime(timestamp.Add(time.Hour)), Token: "iot"},
+	}
+	data := []DataPoint{{Date: "2023-05-05", Token: "hnt", Price: d("3.333")}, {Date: "2023-05-05", Token: "iot", Price: d("0.25")}}
+
+	day := dateAtStartOfDay(timestamp)
+	prices := map[string]PriceQuotesByTime{
+		"hnt": {day: {d("3.333"), "coingecko", PriceExact}},
+		"iot": {day: {d("0.25"), "coingecko", PriceExact}},
+	}
+
+	checks := []struct {
+		syntax    LedgerSyntax
+		extension string
+		command   []string
+	}{
+		{LedgerBeancount, "beancount", []string{"bean-check"}},
+		{LedgerHledger, "journal", []string{"hledger", "check", "--strict", "-f"}},
+	}
+	ran := 0
+
+	for _, check := range checks {
+		tool, err := exec.LookPath(check.command[0])
+		if err != nil {
+			t.Logf("%s isn't installed, skipping the %s check", check.command[0], check.syntax)
+			continue
+		}
+
+		var buf bytes.Buffer
+		err = writeLedger(&buf, check.syntax, "wallet", 2023, rewards, data, prices)
+		if err != nil {
+			t.Fatalf("Failure %s", err)
+		}
+
+		path := filepath.Join(t.TempDir(), "rewards."+check.extension)
+		os.WriteFile(path, buf.Bytes(), 0o644)
+
+		output, err := exec.Command(tool, append(check.command[1:], path)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s rejected the journal %s\n%s\n%s", check.command[0], err, output, buf.String())
+		}
+
+		ran++
+	}
+
+	if ran == 0 {
+		t.Skip("neither bean-check nor hledger is installed")
+	}
+}
@@ -4,26 +4,66 @@ import (
 	"github.com/gin-gonic/gin"
 	// "github.com/heroku/x/hmetrics/onload"
 	// 	"github.com/garfield-yin/gin-error-handler"
-	"github.com/memcachier/mc"
 	// 	"io"
-	"encoding/json"
+	"bytes"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 const RESULT_CACHE_TTL = 86400
+const JOB_CACHE_TTL = 86400
+const JOB_STALE_AFTER = 30 * time.Minute
+const JOB_HEARTBEAT_INTERVAL = time.Minute
 const URL_CACHE_TTL = 3600
-const MAX_YEAR = 2024
-const MIN_YEAR = 2020
 
 func main() {
-	username := os.Getenv("MEMCACHIER_USERNAME")
-	password := os.Getenv("MEMCACHIER_PASSWORD")
-	server := os.Getenv("MEMCACHIER_SERVERS")
+	if len(os.Args) > 1 && os.Args[1] == "import-l1" {
+		importErr := runL1Import(os.Args[2:])
+		if importErr != nil {
+			log.Fatalf("Unable to import L1 rewards %s", importErr)
+		}
+		return
+	}
+
+	cache, cacheErr := newCacheFromEnv()
+	if cacheErr != nil {
+		log.Fatal(cacheErr)
+	}
+	defer cache.Close()
 
-	cache := mc.NewMC(server, username, password)
-	defer cache.Quit()
+	taxYearsFile := os.Getenv("TAX_YEARS_FILE")
+	if taxYearsFile != "" {
+		taxYearsErr := loadTaxYears(taxYearsFile)
+		if taxYearsErr != nil {
+			log.Fatalf("Unable to load tax years %s", taxYearsErr)
+		}
+	}
+
+	timezone := os.Getenv("REPORT_TIMEZONE")
+	if timezone != "" {
+		timezoneErr := setReportTimezone(timezone)
+		if timezoneErr != nil {
+			log.Fatalf("Unable to use timezone %s %s", timezone, timezoneErr)
+		}
+	}
+
+	l1RewardsDir := os.Getenv("L1_REWARDS_DIR")
+	if l1RewardsDir != "" {
+		store, storeErr := newL1RewardStore(l1RewardsDir)
+		if storeErr != nil {
+			log.Fatalf("Unable to open the L1 reward store %s", storeErr)
+		}
+		l1RewardStore = store
+	}
+
+	tokensErr := registerTokens(os.Getenv("TOKEN_REGISTRY"))
+	if tokensErr != nil {
+		log.Fatalf("Unable to register tokens %s", tokensErr)
+	}
 
 	port := os.Getenv("PORT")
 
@@ -50,24 +90,70 @@ func main() {
 			return
 		}
 
+		// Do we have cached data for this request?
+		dataKey := cacheKey(address, taxYear)
+		_, cacheReadErr := cache.Get(dataKey)
+		hasCachedData := cacheReadErr == nil
+
+		// Is there already a job in flight, or one that finished and is still cached?
+		job, jobReadErr := loadJobForRequest(address, taxYear, cache)
+
+		if jobReadErr == nil && (!job.isTerminal() || (job.State == JobSucceeded && hasCachedData)) {
+			log.Printf("Existing job %s is %s, skipping processing", job.Id, job.State)
+			c.JSON(http.StatusOK, gin.H{
+				"enqueued": true,
+				"job":      job,
+			})
+			return
+		}
+
+		job = newJob(address, taxYear)
+
+		if hasCachedData {
+			log.Println("Cached hit, skipping processing")
+			job.State = JobSucceeded
+		}
+
+		jobSaveErr := saveJob(job, cache)
+
+		if jobSaveErr != nil {
+			log.Printf("Unable to save job %s %s", job.Id, jobSaveErr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Unable to enqueue job",
+			})
+			c.Abort()
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"enqueued": true,
+			"job":      job,
 		})
 
 		// return early
 		c.Abort()
 
-		// Do we have cached data for this request?
-		dataKey := cacheKey(address, taxYear)
-		_, _, _, cacheReadErr := cache.Get(dataKey)
+		if job.State == JobQueued {
+			// Fetch the data async
+			go runJob(job, cache)
+		}
+	})
 
-		if cacheReadErr == nil {
-			log.Println("Cached hit, skipping processing")
+	// get the status of a job
+	router.GET("/jobs/:id", func(c *gin.Context) {
+		job, jobReadErr := loadJob(c.Param("id"), cache)
+
+		if jobReadErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Job not found",
+			})
+			c.Abort()
 			return
 		}
 
-		// Fetch the data async
-		go fetchData(address, taxYear, cache)
+		c.JSON(http.StatusOK, gin.H{
+			"job": job,
+		})
 	})
 
 	// get the data
@@ -75,6 +161,10 @@ func main() {
 		address := c.Param("address")
 		taxYear, taxYearParseError := parseTaxYear(c.Query("tax_year"))
 
+		// /data/:address.csv, gin can't route on part of a segment
+		asCSV := strings.HasSuffix(address, ".csv") || strings.Contains(c.GetHeader("Accept"), "text/csv")
+		address = strings.TrimSuffix(address, ".csv")
+
 		if taxYearParseError != nil {
 			c.JSON(400, gin.H{
 				"error": "Invalid year provided",
@@ -83,24 +173,245 @@ func main() {
 			return
 		}
 
-		dataKey := cacheKey(address, taxYear)
-		cachedData, _, _, cacheReadErr := cache.Get(dataKey)
+		data, cacheReadErr := loadCachedData(address, taxYear, cache)
 
 		if cacheReadErr != nil {
 			log.Printf("Cache error %s", cacheReadErr)
-			c.JSON(425, gin.H{
-				"data": nil,
+			respondWithJobStatus(c, address, taxYear, cache)
+			return
+		}
+
+		log.Printf("Cache data found")
+
+		if format := c.Query("format"); asCSV && format != "" {
+			rewards, rewardsReadErr := loadCachedRewards(address, taxYear, cache)
+			if rewardsReadErr != nil {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": "No rewards found, enqueue this request again",
+				})
+				c.Abort()
+				return
+			}
+
+			var buf bytes.Buffer
+
+			exportErr := writeTaxExport(&buf, format, rewards, data)
+			if exportErr != nil {
+				c.JSON(400, gin.H{
+					"error": exportErr.Error(),
+				})
+				c.Abort()
+				return
+			}
+
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"hnt-%s-%d-%s.csv\"", address, taxYear, strings.ToLower(format)))
+			c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+			return
+		}
+
+		if asCSV {
+			byHotspot := c.Query("hotspots") == "true"
+
+			columns, columnsErr := parseCSVColumns(c.Query("columns"), byHotspot)
+			if columnsErr != nil {
+				c.JSON(400, gin.H{
+					"error": columnsErr.Error(),
+				})
+				c.Abort()
+				return
+			}
+
+			var buf bytes.Buffer
+
+			csvErr := writeDataCSV(&buf, taxYear, data, columns, byHotspot)
+			if csvErr != nil {
+				log.Printf("Unable to write CSV %s %s", address, csvErr)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Unable to write CSV",
+				})
+				c.Abort()
+				return
+			}
+
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"hnt-%s-%d.csv\"", address, taxYear))
+			c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data":     data,
+			"summary":  summariseReport(taxYear, data),
+			"warnings": priceWarnings(data),
+		})
+	})
+
+	// the summary without the daily data, or the whole report as .xlsx, .pdf, .beancount or .journal
+	router.GET("/report/:address", func(c *gin.Context) {
+		address := c.Param("address")
+		taxYear, taxYearParseError := parseTaxYear(c.Query("tax_year"))
+
+		format := ""
+		if index := strings.LastIndex(address, "."); index >= 0 {
+			address, format = address[:index], address[index+1:]
+		}
+
+		if taxYearParseError != nil {
+			c.JSON(400, gin.H{
+				"error": "Invalid year provided",
 			})
 			c.Abort()
 			return
 		}
 
-		log.Printf("Cache data found")
-		var data []DataPoint
-		json.Unmarshal([]byte(cachedData), &data)
+		data, cacheReadErr := loadCachedData(address, taxYear, cache)
+
+		if cacheReadErr != nil {
+			respondWithJobStatus(c, address, taxYear, cache)
+			return
+		}
+
+		if syntax, ok := ledgerSyntaxByExtension[format]; ok {
+			rewards, rewardsReadErr := loadCachedRewards(address, taxYear, cache)
+			if rewardsReadErr != nil {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": "No rewards found, enqueue this request again",
+				})
+				c.Abort()
+				return
+			}
+
+			start, end := taxYearBounds(taxYear)
+			pricesByToken := make(map[string]PriceQuotesByTime)
+
+			for _, point := range data {
+				if _, ok := pricesByToken[point.Token]; ok {
+					continue
+				}
+
+				prices, priceErr := filledPrices(point.Token, cache, start, end)
+				if priceErr != nil {
+					log.Printf("Unable to fetch prices for ledger %s %s", address, priceErr)
+					c.JSON(upstreamErrorStatus(priceErr), gin.H{
+						"error": priceErr.Error(),
+					})
+					c.Abort()
+					return
+				}
+
+				pricesByToken[point.Token] = prices
+			}
+
+			var buf bytes.Buffer
+
+			ledgerErr := writeLedger(&buf, syntax, address, taxYear, rewards, data, pricesByToken)
+			if ledgerErr != nil {
+				log.Printf("Unable to write ledger %s %s", address, ledgerErr)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Unable to write ledger",
+				})
+				c.Abort()
+				return
+			}
+
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"hnt-%s-%d.%s\"", address, taxYear, format))
+			c.Data(http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+			return
+		}
+
+		if writer, ok := reportWriters[format]; ok {
+			var buf bytes.Buffer
+
+			writeErr := writer.write(&buf, address, taxYear, data, time.Now())
+			if writeErr != nil {
+				log.Printf("Unable to write %s report %s %s", format, address, writeErr)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": fmt.Sprintf("Unable to write %s report", format),
+				})
+				c.Abort()
+				return
+			}
+
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"hnt-%s-%d.%s\"", address, taxYear, format))
+			c.Data(http.StatusOK, writer.contentType, buf.Bytes())
+			return
+		}
+
+		if format != "" {
+			c.JSON(400, gin.H{
+				"error": fmt.Sprintf("Unknown report format %s", format),
+			})
+			c.Abort()
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"summary":  summariseReport(taxYear, data),
+			"warnings": priceWarnings(data),
+		})
+	})
+
+	// work out the capital gains on disposals of the rewards
+	router.POST("/gains/:address", func(c *gin.Context) {
+		address := c.Param("address")
+		taxYear, taxYearParseError := parseTaxYear(c.Query("tax_year"))
+
+		if taxYearParseError != nil {
+			c.JSON(400, gin.H{
+				"error": "Invalid year provided",
+			})
+			c.Abort()
+			return
+		}
+
+		var request GainsRequest
+		bindErr := c.ShouldBindJSON(&request)
+
+		if bindErr != nil {
+			c.JSON(400, gin.H{
+				"error": fmt.Sprintf("Invalid request %s", bindErr),
+			})
+			c.Abort()
+			return
+		}
+
+		disposalsErr := checkDisposalsInTaxYear(request.Disposals, taxYear)
+
+		if disposalsErr != nil {
+			c.JSON(400, gin.H{
+				"error": disposalsErr.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		data, cacheReadErr := loadCachedData(address, taxYear, cache)
+
+		if cacheReadErr != nil {
+			respondWithJobStatus(c, address, taxYear, cache)
+			return
+		}
+
+		// disposals late in the year can be matched against the first rewards of the next
+		var later []Acquisition
+		nextData, nextCacheReadErr := loadCachedData(address, taxYear+1, cache)
+		if nextCacheReadErr == nil {
+			later = bedAndBreakfastAcquisitions(nextData, taxYear)
+		}
+
+		report, gainsErr := calculateGains(acquisitionsFromDataPoints(data), later, request.Disposals, request.OpeningPools)
+
+		if gainsErr != nil {
+			c.JSON(400, gin.H{
+				"error": gainsErr.Error(),
+			})
+			c.Abort()
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"data": data,
+			"disposals": report.Disposals,
+			"pools":     report.Pools,
+			"summary":   report.summaryFor(taxYear),
 		})
 	})
 
@@ -108,23 +419,64 @@ func main() {
 	router.GET("/balance/:address", func(c *gin.Context) {
 		address := c.Param("address")
 
-		balance := fetchBalance(address, cache)
+		balance, err := fetchBalance(address, cache)
+
+		if err != nil {
+			log.Printf("Unable to fetch balance %s %s", address, err)
+			c.JSON(upstreamErrorStatus(err), gin.H{
+				"error": err.Error(),
+			})
+			c.Abort()
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"balance": balance,
 		})
 	})
 
+	// the hotspots a wallet holds on Solana
+	router.GET("/hotspots/:address", func(c *gin.Context) {
+		address := c.Param("address")
+
+		hotspots, err := fetchSolanaHotspots(address, cache)
+
+		if err != nil {
+			log.Printf("Unable to fetch hotspots %s %s", address, err)
+			c.JSON(upstreamErrorStatus(err), gin.H{
+				"error": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"hotspots": hotspots,
+		})
+	})
+
 	router.GET("/solana/balance/:address/:token", func(c *gin.Context) {
 		address := c.Param("address")
 		token := c.Param("token")
 
+		if strings.ToLower(token) != "sol" {
+			_, tokenErr := resolveTokenMint(token)
+
+			if tokenErr != nil {
+				c.JSON(400, gin.H{
+					"err": tokenErr.Error(),
+				})
+				c.Abort()
+				return
+			}
+		}
+
 		balance, err := fetchSolanaAccountBalance(address, token, cache)
 
 		if err != nil {
 			log.Printf("Unable to fetch solana balance %s %s %s", address, token, err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"err": err,
+			c.JSON(upstreamErrorStatus(err), gin.H{
+				"err": err.Error(),
 			})
 			c.Abort()
 			return
@@ -144,7 +496,7 @@ func main() {
 		if err != nil {
 			log.Printf("Unable to get the price for %s %v", token, err)
 			c.JSON(400, gin.H{
-				"error": "Bad token provided",
+				"error": fmt.Sprintf("Bad token provided, %s", err),
 			})
 			c.Abort()
 			return
@@ -155,9 +507,48 @@ func main() {
 		})
 	})
 
+	// the tax years we can report on, and the rates in force
+	router.GET("/tax-years", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"tax_years": taxYears,
+			"default":   latestSupportedTaxYear(),
+		})
+	})
+
 	router.GET("/", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "index.tmpl.html", nil)
 	})
 
 	router.Run(":" + port)
 }
+
+// answers a request for data that isn't cached yet with what the job is doing
+func respondWithJobStatus(c *gin.Context, address string, taxYear int, cache Cache) {
+	job, jobReadErr := loadJobForRequest(address, taxYear, cache)
+
+	// nothing is running that could ever produce this data
+	if jobReadErr != nil || job.State == JobSucceeded {
+		c.JSON(http.StatusNotFound, gin.H{
+			"data":  nil,
+			"error": "No data found, enqueue this request first",
+		})
+		c.Abort()
+		return
+	}
+
+	if job.State == JobFailed {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"data":  nil,
+			"error": job.Error,
+			"job":   job,
+		})
+		c.Abort()
+		return
+	}
+
+	c.JSON(425, gin.H{
+		"data": nil,
+		"job":  job,
+	})
+	c.Abort()
+}
@@ -7,8 +7,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/memcachier/mc"
 )
 
 type PricesBytime = map[time.Time]float64
@@ -64,29 +62,103 @@ func convert(tuples []PriceTimeTuple) PricesBytime {
 	return hash
 }
 
-func getMarketData(cache *mc.Client, startTime time.Time, endTime time.Time) PricesBytime {
+// maps our asset tickers to coingecko's coin ids
+var coinGeckoIdentifierByAsset = map[string]string{
+	"hnt":    "helium",
+	"iot":    "helium-iot",
+	"mobile": "helium-mobile",
+}
+
+type CoinGeckoProvider struct {
+	currency string
+}
+
+func (provider *CoinGeckoProvider) Name() string {
+	return "coingecko"
+}
+
+func (provider *CoinGeckoProvider) SupportedAssets() []string {
+	var assets []string
+
+	for asset := range coinGeckoIdentifierByAsset {
+		assets = append(assets, asset)
+	}
+
+	return assets
+}
+
+func (provider *CoinGeckoProvider) DailyPrices(asset string, startTime time.Time, endTime time.Time, cache Cache) (PricesBytime, error) {
+	identifier, ok := coinGeckoIdentifierByAsset[asset]
+	if !ok {
+		return nil, fmt.Errorf("%s is not supported", asset)
+	}
+
 	url := fmt.Sprintf(
-		"https://api.coingecko.com/api/v3/coins/helium/market_chart/range?vs_currency=GBP&from=%d&to=%d",
+		"https://api.coingecko.com/api/v3/coins/%s/market_chart/range?vs_currency=%s&from=%d&to=%d",
+		identifier,
+		provider.currency,
 		startTime.Unix(),
 		endTime.Unix())
 
-	response := fetchUrl(url, cache)
-
 	var marketData MarketChart
 
-	json.Unmarshal(response, &marketData)
+	err := fetchValidJson(url, cache, &marketData, func() error {
+		// a rate limited response is still valid json, just without any prices
+		if len(marketData.Prices) == 0 {
+			return fmt.Errorf("no prices returned for %s", identifier)
+		}
 
-	hash := convert(marketData.Prices)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	return hash
+	return convert(marketData.Prices), nil
 }
 
-func getIdentifierBySymbolMap(cache *mc.Client) (map[string]string, map[string]bool) {
-	response := fetchUrl("https://api.coingecko.com/api/v3/coins/list", cache)
+func (provider *CoinGeckoProvider) SpotPrice(tickerOrIdentifier string, cache Cache) (float64, error) {
+	identifier, err := getValidIdentifier(tickerOrIdentifier, cache)
+	if err != nil {
+		return 0, err
+	}
+
+	if identifier == "" {
+		return 0, fmt.Errorf("unknown coin %s", tickerOrIdentifier)
+	}
+
+	url := fmt.Sprintf("https://api.coingecko.com/api/v3/simple/price?ids=%s&vs_currencies=%s", identifier, provider.currency)
+
+	var rootObject map[string]json.RawMessage
+	var currencyValue map[string]float64
+
+	err = fetchValidJson(url, cache, &rootObject, func() error {
+		currencyValueError := json.Unmarshal(rootObject[identifier], &currencyValue)
+		if currencyValueError != nil {
+			log.Printf("Parsing failed: %s", currencyValueError)
+			return &DecodeError{url, currencyValueError}
+		}
+
+		if _, ok := currencyValue[provider.currency]; !ok {
+			return fmt.Errorf("no %s price for %s", provider.currency, identifier)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return currencyValue[provider.currency], nil
+}
 
+func getIdentifierBySymbolMap(cache Cache) (map[string]string, map[string]bool, error) {
 	var coins []Coin
 
-	json.Unmarshal(response, &coins)
+	err := fetchJson("https://api.coingecko.com/api/v3/coins/list", cache, &coins)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	identifierBySymbol := make(map[string]string)
 
@@ -98,41 +170,34 @@ func getIdentifierBySymbolMap(cache *mc.Client) (map[string]string, map[string]b
 		identifierBySymbol[coin.Symbol] = coin.Identifier
 	}
 
-	return identifierBySymbol, boolByIdentifier
+	return identifierBySymbol, boolByIdentifier, nil
 }
 
-func getValidIdentifier(symbolOrIdentifier string, cache *mc.Client) string {
+func getValidIdentifier(symbolOrIdentifier string, cache Cache) (string, error) {
 	input := strings.ToLower(symbolOrIdentifier)
 
-	identifierBySymbol, boolByIdentifier := getIdentifierBySymbolMap(cache)
+	if identifier, ok := coinGeckoIdentifierByAsset[input]; ok {
+		return identifier, nil
+	}
+
+	identifierBySymbol, boolByIdentifier, err := getIdentifierBySymbolMap(cache)
+	if err != nil {
+		return "", err
+	}
 
 	if _, ok := boolByIdentifier[input]; ok {
-		return input
+		return input, nil
 	}
 
-	return identifierBySymbol[input]
+	return identifierBySymbol[input], nil
 }
 
-func getMarketPrice(tickerOrIdentifier string, cache *mc.Client) (float64, error) {
-	identifier := getValidIdentifier(tickerOrIdentifier, cache)
-	url := fmt.Sprintf("https://api.coingecko.com/api/v3/simple/price?ids=%s&vs_currencies=gbp", identifier)
-
-	response := fetchUrl(url, cache)
-
-	var rootObject map[string]json.RawMessage
-	rootObjectError := json.Unmarshal(response, &rootObject)
-
-	if rootObjectError != nil {
-		log.Println(rootObjectError)
-		return 0, rootObjectError
-	}
+func getMarketData(token string, cache Cache, startTime time.Time, endTime time.Time) (PriceQuotesByTime, error) {
+	return defaultPriceProviders.DailyQuotes(token, startTime, endTime, cache)
+}
 
-	var currencyValue map[string]float64
-	currencyValueError := json.Unmarshal(rootObject[identifier], &currencyValue)
-	if currencyValueError != nil {
-		log.Printf("Parsing failed: %s %s", currencyValueError, string(response))
-		return 0, currencyValueError
-	}
+func getMarketPrice(tickerOrIdentifier string, cache Cache) (float64, error) {
+	price, _, err := defaultPriceProviders.SpotPrice(tickerOrIdentifier, cache)
 
-	return currencyValue["gbp"], nil
+	return price, err
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+/*
+ A hotspot changing hands. On the L1 it was a transfer_hotspot
+ transaction, on Solana it's a transfer of the hotspot's NFT.
+*/
+type HotspotTransfer struct {
+	Hotspot   string     `json:"hotspot"`
+	From      string     `json:"from"`
+	To        string     `json:"to"`
+	Timestamp RewardTime `json:"timestamp"`
+	Hash      string     `json:"hash,omitempty"`
+	// the L1 block or Solana slot, transfers can share a timestamp
+	Block int64 `json:"block,omitempty"`
+}
+
+// the wallet owned the hotspot from From until To, a zero From or To is open ended
+type OwnershipWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+func (window OwnershipWindow) contains(t time.Time) bool {
+	return !t.Before(window.From) && (window.To.IsZero() || t.Before(window.To))
+}
+
+// keyed by hotspot address
+type OwnershipWindows map[string][]OwnershipWindow
+
+/*
+ Sorts one hotspot's transfers oldest first. Transfers in the same block are put in the order the
+ hotspot could have moved, each one from whoever the last gave it to,
+ whatever order they were fetched in.
+*/
+func sortTransfers(transfers []HotspotTransfer) {
+	sort.Slice(transfers, func(i, j int) bool {
+		a, b := transfers[i], transfers[j]
+
+		switch {
+		case !time.Time(a.Timestamp).Equal(time.Time(b.Timestamp)):
+			return time.Time(a.Timestamp).Before(time.Time(b.Timestamp))
+		case a.Block != b.Block:
+			return a.Block < b.Block
+		case a.Hash != b.Hash:
+			return a.Hash < b.Hash
+		case a.From != b.From:
+			return a.From < b.From
+		}
+
+		return a.To < b.To
+	})
+
+	holder := ""
+
+	for start := 0; start < len(transfers); {
+		end := start + 1
+		for end < len(transfers) && time.Time(transfers[end].Timestamp).Equal(time.Time(transfers[start].Timestamp)) &&
+			transfers[end].Block == transfers[start].Block {
+			end++
+		}
+
+		for i := start; i < end; i++ {
+			next := -1
+
+			// the transfer from the holder, or failing that one from someone nobody else in the block sent it to
+			for j := i; j < end && next < 0; j++ {
+				if holder != "" && transfers[j].From == holder {
+					next = j
+				}
+			}
+
+			for j := i; j < end && next < 0; j++ {
+				if !receivedFrom(transfers[i:end], transfers[j].From) {
+					next = j
+				}
+			}
+
+			if next < 0 {
+				next = i
+			}
+
+			transfers[i], transfers[next] = transfers[next], transfers[i]
+			holder = transfers[i].To
+		}
+
+		start = end
+	}
+}
+
+func receivedFrom(transfers []HotspotTransfer, account string) bool {
+	for _, transfer := range transfers {
+		if transfer.To == account {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ Walks each hotspot's transfers in order, the wallet owns it from a
+ transfer to the wallet until a transfer away. Transfers are fetched from
+ the start of the report onwards, so a hotspot whose first one is away
+ from the wallet was owned from before the report started, and one whose
+ first is to the wallet wasn't owned until then, even if that's after the
+ report ends.
+*/
+func ownershipWindows(wallet string, transfers []HotspotTransfer) OwnershipWindows {
+	byHotspot := make(map[string][]HotspotTransfer)
+
+	for _, transfer := range transfers {
+		byHotspot[transfer.Hotspot] = append(byHotspot[transfer.Hotspot], transfer)
+	}
+
+	windows := make(OwnershipWindows)
+
+	for hotspot, transfers := range byHotspot {
+		sortTransfers(transfers)
+
+		var open *time.Time
+		seen := false
+
+		for _, transfer := range transfers {
+			timestamp := time.Time(transfer.Timestamp)
+
+			// only transfers in or out of the wallet change what it owns
+			if (transfer.From == wallet) == (transfer.To == wallet) {
+				continue
+			}
+
+			first := !seen
+			seen = true
+
+			if transfer.To == wallet {
+				if open == nil {
+					open = &timestamp
+				}
+				continue
+			}
+
+			switch {
+			case open != nil:
+				windows[hotspot] = append(windows[hotspot], OwnershipWindow{*open, timestamp})
+				open = nil
+			case first:
+				windows[hotspot] = append(windows[hotspot], OwnershipWindow{To: timestamp})
+			}
+		}
+
+		if open != nil {
+			windows[hotspot] = append(windows[hotspot], OwnershipWindow{From: *open})
+		}
+
+		// the wallet never held it long enough to own it, e.g. a transfer it sent straight back
+		if _, ok := windows[hotspot]; !ok && seen {
+			windows[hotspot] = []OwnershipWindow{}
+		}
+	}
+
+	return windows
+}
+
+func (windows OwnershipWindows) owned(hotspot string, t time.Time) bool {
+	hotspotWindows, ok := windows[hotspot]
+
+	// no transfers in or out, so it was the wallet's throughout
+	if !ok {
+		return true
+	}
+
+	for _, window := range hotspotWindows {
+		if window.contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ The rewards earned while the wallet owned the hotspot. Rewards we can't
+ tie to a hotspot, and hotspots that never changed hands, can't be
+ checked so they're kept.
+*/
+func (windows OwnershipWindows) credit(rewards []Reward) []Reward {
+	var credited []Reward
+
+	for _, reward := range rewards {
+		if reward.Gateway == "" || windows.owned(reward.Gateway, time.Time(reward.Timestamp)) {
+			credited = append(credited, reward)
+		}
+	}
+
+	return credited
+}
+
+// the hotspots the wallet owned at some point, including any it has since sold
+func (windows OwnershipWindows) hotspots() []string {
+	var hotspots []string
+
+	for hotspot, hotspotWindows := range windows {
+		if len(hotspotWindows) > 0 {
+			hotspots = append(hotspots, hotspot)
+		}
+	}
+
+	sort.Strings(hotspots)
+
+	return hotspots
+}
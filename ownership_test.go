@@ -0,0 +1,125 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOwnershipWindows(t *testing.T) {
+	day := func(month time.Month, date int) time.Time {
+		return time.Date(2022, month, date, 12, 0, 0, 0, time.UTC)
+	}
+
+	transfers := []HotspotTransfer{
+		// sold in june, owned since before the report
+		{Hotspot: "sold", From: "wallet", To: "buyer", Timestamp: RewardTime(day(time.June, 1))},
+		// bought in october
+		{Hotspot: "bought", From: "seller", To: "wallet", Timestamp: RewardTime(day(time.October, 1))},
+		// bought in may and sold in august
+		{Hotspot: "flipped", From: "wallet", To: "buyer", Timestamp: RewardTime(day(time.August, 1))},
+		{Hotspot: "flipped", From: "seller", To: "wallet", Timestamp: RewardTime(day(time.May, 1))},
+		// changed hands without the wallet
+		{Hotspot: "elsewhere", From: "seller", To: "buyer", Timestamp: RewardTime(day(time.July, 1))},
+	}
+
+	windows := ownershipWindows("wallet", transfers)
+
+	reward := func(hotspot string, month time.Month) Reward {
+		return Reward{Gateway: hotspot, Amount: int64(month), Timestamp: RewardTime(day(month, 15))}
+	}
+
+	rewards := []Reward{
+		reward("sold", time.April),
+		reward("sold", time.July),
+		reward("bought", time.April),
+		reward("bought", time.November),
+		reward("flipped", time.April),
+		reward("flipped", time.June),
+		reward("flipped", time.September),
+		reward("kept", time.March),
+		reward("elsewhere", time.March),
+		reward("", time.December),
+	}
+
+	credited := windows.credit(rewards)
+
+	expected := []Reward{
+		reward("sold", time.April),
+		reward("bought", time.November),
+		reward("flipped", time.June),
+		reward("kept", time.March),
+		reward("elsewhere", time.March),
+		reward("", time.December),
+	}
+
+	if len(credited) != len(expected) {
+		t.Fatalf("Unexpected credited rewards %+v", credited)
+	}
+
+	for i := range expected {
+		if credited[i].Gateway != expected[i].Gateway || credited[i].Amount != expected[i].Amount {
+			t.Fatalf("Expected %+v got %+v", expected[i], credited[i])
+		}
+	}
+
+	hotspots := windows.hotspots()
+	if len(hotspots) != 3 || hotspots[0] != "bought" || hotspots[1] != "flipped" || hotspots[2] != "sold" {
+		t.Fatalf("Unexpected hotspots %+v", hotspots)
+	}
+}
+
+func TestOwnershipWindowsSentStraightBack(t *testing.T) {
+	transfers := []HotspotTransfer{
+		{Hotspot: "returned", From: "seller", To: "wallet", Timestamp: RewardTime(time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))},
+		{Hotspot: "returned", From: "wallet", To: "seller", Timestamp: RewardTime(time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))},
+	}
+
+	windows := ownershipWindows("wallet", transfers)
+
+	if windows.owned("returned", time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)) || windows.owned("returned", time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("The wallet never held the hotspot %+v", windows)
+	}
+}
+
+func TestOwnershipWindowsBoughtAfterTheReport(t *testing.T) {
+	// a 2021/22 report, the hotspot is listed as the wallet's because it was bought in october 2022
+	transfers := []HotspotTransfer{
+		{Hotspot: "later", From: "seller", To: "wallet", Timestamp: RewardTime(time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC))},
+	}
+
+	windows := ownershipWindows("wallet", transfers)
+
+	rewards := []Reward{
+		{Gateway: "later", Amount: 1, Timestamp: RewardTime(time.Date(2021, 12, 1, 12, 0, 0, 0, time.UTC))},
+	}
+
+	if credited := windows.credit(rewards); len(credited) != 0 {
+		t.Fatalf("The previous owner's rewards were credited %+v", credited)
+	}
+}
+
+func TestOwnershipWindowsSameBlock(t *testing.T) {
+	timestamp := RewardTime(time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	// sold and bought back in one block, fetched newest first
+	transfers := []HotspotTransfer{
+		{Hotspot: "hotspot", From: "buyer", To: "wallet", Timestamp: timestamp, Block: 10, Hash: "hash-a"},
+		{Hotspot: "hotspot", From: "wallet", To: "buyer", Timestamp: timestamp, Block: 10, Hash: "hash-b"},
+		{Hotspot: "hotspot", From: "seller", To: "wallet", Timestamp: RewardTime(time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC)), Block: 5},
+	}
+
+	for _, order := range [][]int{{0, 1, 2}, {1, 0, 2}, {2, 1, 0}} {
+		var ordered []HotspotTransfer
+		for _, i := range order {
+			ordered = append(ordered, transfers[i])
+		}
+
+		windows := ownershipWindows("wallet", ordered)
+
+		if windows.owned("hotspot", time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)) ||
+			!windows.owned("hotspot", time.Date(2022, 4, 15, 0, 0, 0, 0, time.UTC)) ||
+			!windows.owned("hotspot", time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Fatalf("Unexpected windows for order %v %+v", order, windows)
+		}
+	}
+}
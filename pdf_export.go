@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+/*
+ A minimal PDF 1.4 writer, just enough for the report: A4 pages of text
+ and rules in the standard Helvetica fonts, which every reader has, so
+ nothing is embedded. Text is WinAnsi encoded for the £ sign.
+ https://opensource.adobe.com/dc-acrobat-sdk-docs/pdfstandards/pdfreference1.4.pdf
+*/
+
+const PDF_CONTENT_TYPE = "application/pdf"
+
+// A4 in points
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 50.0
+)
+
+const (
+	pdfFontRegular = "F1"
+	pdfFontBold    = "F2"
+)
+
+// Helvetica's advance widths for ASCII 32 to 126, in 1/1000 of the font size
+var helveticaWidths = []int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// digits and punctuation are the same width in bold, which is all we right align
+func pdfTextWidth(text string, size float64) float64 {
+	width := 0
+
+	for _, r := range text {
+		switch {
+		case r >= 32 && r <= 126:
+			width += helveticaWidths[r-32]
+		default:
+			// £ and anything we can't encode, which is drawn as ?
+			width += 556
+		}
+	}
+
+	return float64(width) * size / 1000
+}
+
+// a PDF string literal in WinAnsiEncoding
+func pdfString(text string) string {
+	var buf strings.Builder
+
+	buf.WriteByte('(')
+
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r >= 32 && r <= 126:
+			buf.WriteRune(r)
+		case r == '£':
+			buf.WriteString(`\243`)
+		default:
+			buf.WriteByte('?')
+		}
+	}
+
+	buf.WriteByte(')')
+
+	return buf.String()
+}
+
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+func (page *pdfPage) text(x float64, y float64, font string, size float64, text string) {
+	fmt.Fprintf(&page.content, "BT /%s %.1f Tf %.2f %.2f Td %s Tj ET\n", font, size, x, y, pdfString(text))
+}
+
+func (page *pdfPage) textRight(right float64, y float64, font string, size float64, text string) {
+	page.text(right-pdfTextWidth(text, size), y, font, size, text)
+}
+
+func (page *pdfPage) line(x1 float64, y1 float64, x2 float64, y2 float64) {
+	fmt.Fprintf(&page.content, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+type pdfDocument struct {
+	pages []*pdfPage
+}
+
+func (doc *pdfDocument) newPage() *pdfPage {
+	page := &pdfPage{}
+	doc.pages = append(doc.pages, page)
+
+	return page
+}
+
+func (doc *pdfDocument) write(w io.Writer) error {
+	var buf bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// 1 and 2 are the catalog and page tree, 3 and 4 the fonts, then a page and its contents for each page
+	var kids []string
+	for i := range doc.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+i*2))
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(doc.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range doc.pages {
+		object(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, pdfFontRegular, pdfFontBold, 6+i*2))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.content.Len(), page.content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+// breaks text into lines no wider than width
+func pdfWrap(text string, size float64, width float64) []string {
+	var lines []string
+	line := ""
+
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+
+		if line != "" && pdfTextWidth(candidate, size) > width {
+			lines = append(lines, line)
+			line = word
+			continue
+		}
+
+		line = candidate
+	}
+
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// a right aligned column of a table, x is its right edge
+type pdfColumn struct {
+	header string
+	right  float64
+}
+
+const pdfRowHeight = 14.0
+
+const pdfDailyRowsPerPage = 48
+
+var priceProviderTitles = map[string]string{
+	"coingecko":     "CoinGecko",
+	"cryptocompare": "CryptoCompare",
+}
+
+func priceProviderTitle(name string) string {
+	if title, ok := priceProviderTitles[name]; ok {
+		return title
+	}
+
+	return name
+}
+
+// "a", "a and b", "a, b and c"
+func joinWithAnd(items []string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
+
+/*
+ How the report was worked out, from the price providers configured and
+ used, and where the rewards for the tax year came from.
+*/
+func pdfMethodology(taxYear int, data []DataPoint) []string {
+	var configured []string
+	for _, provider := range defaultPriceProviders.providers {
+		configured = append(configured, priceProviderTitle(provider.Name()))
+	}
+
+	var used []string
+	for _, source := range priceSources(data) {
+		used = append(used, priceProviderTitle(source))
+	}
+
+	prices := "Each token's daily GBP price is taken from " + joinWithAnd(configured) + "."
+	if len(configured) > 1 {
+		prices = "Each token's daily GBP price is taken from the first of " + strings.Join(configured, ", then ") +
+			" to have it, later sources only fill in the days the earlier ones are missing."
+	}
+
+	if len(used) > 0 {
+		prices += " The prices in this report came from " + joinWithAnd(used) + "."
+	}
+
+	l1 := "the Helium API"
+	if l1RewardStore != nil {
+		l1 = "Helium ETL dumps and archived Helium API pages"
+	}
+
+	start, end := taxYearBounds(taxYear)
+	migration := SOLANA_MIGRATION_TIME.Format("2 January 2006")
+
+	var rewards string
+	switch {
+	case !end.After(SOLANA_MIGRATION_TIME):
+		rewards = "Rewards come from " + l1 + "."
+	case !start.Before(SOLANA_MIGRATION_TIME):
+		rewards = "Rewards come from the wallet's reward claims on Solana."
+	default:
+		rewards = "Rewards before the Solana migration on " + migration + " come from " + l1 +
+			", and rewards after it from the wallet's reward claims on Solana."
+	}
+
+	rewards += " A hotspot's rewards are only counted while the wallet owned it, hotspots transferred in or " +
+		"out of the wallet are counted from the day they arrived until the day they left."
+
+	return []string{
+		prices + " " + rewards,
+		"Rewards are grouped into days in the report timezone, and the HNT, IOT and MOBILE earned each day are " +
+			"multiplied by that token's price for the day. Token amounts are added up exactly in their smallest unit and earnings are only rounded to the " +
+			"penny for the totals, so monthly subtotals may differ from the total by a penny.",
+		"Days without a price from any source are filled by the price gap policy below and listed as warnings, " +
+			"check them before you file.",
+	}
+}
+
+/*
+ A cover page with the totals and how they were worked out, a page of
+ monthly subtotals and an appendix with every earning day. The layout
+ only depends on the data, so the same report always has the same pages.
+*/
+func writeReportPDF(w io.Writer, address string, taxYear int, data []DataPoint, generatedAt time.Time) error {
+	summary := summariseReport(taxYear, data)
+	warnings := priceWarnings(data)
+	doc := &pdfDocument{}
+
+	label := fmt.Sprintf("%d/%d", taxYear, taxYear+1)
+	if entry, ok := findTaxYear(taxYear); ok {
+		label = entry.Label
+	}
+
+	start, end := taxYearBounds(taxYear)
+	left := pdfMargin
+	right := pdfPageWidth - pdfMargin
+
+	// cover
+	cover := doc.newPage()
+	y := pdfPageHeight - pdfMargin - 20
+
+	cover.text(left, y, pdfFontBold, 20, "Helium Income Report")
+	y -= 24
+	cover.text(left, y, pdfFontRegular, 12, fmt.Sprintf("Tax year %s", label))
+	y -= 30
+
+	details := [][2]string{
+		{"Address", address},
+		{"Period", fmt.Sprintf("%s to %s", start.Format("2 January 2006"), end.AddDate(0, 0, -1).Format("2 January 2006"))},
+		{"Timezone", reportLocation.String()},
+		{"Price sources", strings.Join(priceSources(data), ", ")},
+		{"Price gaps", fmt.Sprintf("%s, up to %d days", defaultGapFilling.Policy, defaultGapFilling.MaxDays)},
+		{"Generated", generatedAt.UTC().Format("2 January 2006 15:04 MST")},
+	}
+
+	for _, detail := range details {
+		cover.text(left, y, pdfFontBold, 10, detail[0])
+		cover.text(left+110, y, pdfFontRegular, 10, detail[1])
+		y -= pdfRowHeight
+	}
+
+	y -= 16
+	cover.text(left, y, pdfFontBold, 14, "Summary")
+	y -= 6
+	cover.line(left, y, right, y)
+	y -= 18
+
+	totals := [][2]string{{"Total income", "£" + summary.TotalIncome.String()}}
+
+	for _, token := range summary.Tokens {
+		symbol := strings.ToUpper(token.Token)
+		totals = append(totals,
+			[2]string{"Total " + symbol, token.Tokens.String()},
+			[2]string{symbol + " income", "£" + token.Income.String()},
+			[2]string{"Average " + symbol + " price", "£" + token.AveragePrice.String()},
+		)
+	}
+
+	totals = append(totals,
+		[2]string{"Earning days", fmt.Sprintf("%d", summary.EarningDays)},
+		[2]string{"First reward", summary.FirstReward},
+		[2]string{"Last reward", summary.LastReward},
+		[2]string{"Price warnings", fmt.Sprintf("%d", len(warnings))},
+	)
+
+	for _, total := range totals {
+		cover.text(left, y, pdfFontRegular, 11, total[0])
+		cover.textRight(left+300, y, pdfFontBold, 11, total[1])
+		y -= pdfRowHeight + 2
+	}
+
+	y -= 16
+	cover.text(left, y, pdfFontBold, 14, "Methodology")
+	y -= 6
+	cover.line(left, y, right, y)
+	y -= 16
+
+	for _, paragraph := range pdfMethodology(taxYear, data) {
+		for _, line := range pdfWrap(paragraph, 10, right-left) {
+			cover.text(left, y, pdfFontRegular, 10, line)
+			y -= 13
+		}
+		y -= 6
+	}
+
+	// monthly
+	monthly := doc.newPage()
+	y = pdfPageHeight - pdfMargin - 20
+	monthly.text(left, y, pdfFontBold, 14, "Monthly summary")
+	y -= 24
+
+	// a column for each token, then the combined income
+	var monthColumns []pdfColumn
+	var totalValues []string
+
+	for i, token := range summary.Tokens {
+		monthColumns = append(monthColumns, pdfColumn{strings.ToUpper(token.Token), left + 160 + 90*float64(i)})
+		totalValues = append(totalValues, token.Tokens.String())
+	}
+
+	monthColumns = append(monthColumns, pdfColumn{"Earnings (GBP)", right - 90}, pdfColumn{"Earning days", right})
+	totalValues = append(totalValues, summary.TotalIncome.String(), fmt.Sprintf("%d", summary.EarningDays))
+
+	y = pdfTableHeader(monthly, y, "Month", monthColumns)
+
+	for _, month := range summary.Months {
+		var values []string
+		for _, token := range summary.Tokens {
+			values = append(values, month.Tokens[token.Token].String())
+		}
+
+		parsed, _ := time.Parse("2006-01", month.Month)
+		monthly.text(left, y, pdfFontRegular, 10, parsed.Format("January 2006"))
+		pdfTableRow(monthly, y, pdfFontRegular, monthColumns, append(values, month.Income.String(), fmt.Sprintf("%d", month.EarningDays))...)
+		y -= pdfRowHeight
+	}
+
+	monthly.line(left, y+pdfRowHeight-4, right, y+pdfRowHeight-4)
+	monthly.text(left, y-2, pdfFontBold, 10, "Total")
+	pdfTableRow(monthly, y-2, pdfFontBold, monthColumns, totalValues...)
+
+	// daily appendix
+	dayColumns := []pdfColumn{{"Token", left + 130}, {"Amount", left + 220}, {"Price (GBP)", left + 300}, {"Earnings (GBP)", left + 400}, {"Price status", right}}
+
+	for first := 0; first < len(data); first += pdfDailyRowsPerPage {
+		page := doc.newPage()
+		y = pdfPageHeight - pdfMargin - 20
+		page.text(left, y, pdfFontBold, 14, "Appendix: daily earnings")
+		y -= 24
+		y = pdfTableHeader(page, y, "Date", dayColumns)
+
+		for i := first; i < len(data) && i < first+pdfDailyRowsPerPage; i++ {
+			point := data[i]
+			page.text(left, y, pdfFontRegular, 9, point.Date)
+			pdfTableRow(page, y, pdfFontRegular, dayColumns, strings.ToUpper(point.Token), point.Tokens.String(), point.Price.String(), point.Earnings.RoundGBP().String(), string(point.PriceStatus))
+			y -= pdfRowHeight
+		}
+	}
+
+	for i, page := range doc.pages {
+		page.line(left, pdfMargin, right, pdfMargin)
+		page.text(left, pdfMargin-14, pdfFontRegular, 8, fmt.Sprintf("%s, tax year %s", address, label))
+		page.textRight(right, pdfMargin-14, pdfFontRegular, 8, fmt.Sprintf("Page %d of %d", i+1, len(doc.pages)))
+	}
+
+	return doc.write(w)
+}
+
+func pdfTableHeader(page *pdfPage, y float64, first string, columns []pdfColumn) float64 {
+	page.text(pdfMargin, y, pdfFontBold, 10, first)
+
+	for _, column := range columns {
+		page.textRight(column.right, y, pdfFontBold, 10, column.header)
+	}
+
+	page.line(pdfMargin, y-5, pdfPageWidth-pdfMargin, y-5)
+
+	return y - pdfRowHeight - 4
+}
+
+func pdfTableRow(page *pdfPage, y float64, font string, columns []pdfColumn, values ...string) {
+	for i, column := range columns {
+		page.textRight(column.right, y, font, 9, values[i])
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteReportPDF(t *testing.T) {
+	var data []DataPoint
+
+	// enough days for two appendix pages
+	day := time.Date(2023, 4, 6, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 60; i++ {
+		data = append(data, DataPoint{Date: day.AddDate(0, 0, i).Format("2006-01-02"), Token: "hnt", Earnings: d("1.5"), Tokens: d("0.75"), Price: d("2"), PriceStatus: PriceExact})
+	}
+
+	var buf bytes.Buffer
+	err := writeReportPDF(&buf, "wallet (main)", 2023, data, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	pdf := buf.String()
+
+	if !strings.HasPrefix(pdf, "%PDF-1.4") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatalf("Not a PDF")
+	}
+
+	// cover, monthly and two appendix pages
+	if !strings.Contains(pdf, "/Count 4") || !strings.Contains(pdf, "(Page 4 of 4)") {
+		t.Fatalf("Expected four pages")
+	}
+
+	if !strings.Contains(pdf, `(\243`+"90.00)") || !strings.Contains(pdf, `(wallet \(main\), tax year 2023/2024)`) {
+		t.Fatalf("Missing the escaped total or address")
+	}
+
+	// readers find objects through the xref table, every offset has to be exact
+	xref := regexp.MustCompile(`(?m)^(\d{10}) 00000 n $`).FindAllStringSubmatch(pdf, -1)
+
+	for i, entry := range xref {
+		offset, _ := strconv.Atoi(entry[1])
+		if !strings.HasPrefix(pdf[offset:], fmt.Sprintf("%d 0 obj", i+1)) {
+			t.Fatalf("xref entry %d points at the wrong place", i+1)
+		}
+	}
+
+	start := regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(pdf)
+	offset, _ := strconv.Atoi(start[1])
+	if !strings.HasPrefix(pdf[offset:], "xref") {
+		t.Fatalf("startxref points at the wrong place")
+	}
+}
+
+func TestPdfWrap(t *testing.T) {
+	lines := pdfWrap("the quick brown fox jumps over the lazy dog", 10, 100)
+
+	for _, line := range lines {
+		if pdfTextWidth(line, 10) > 100 {
+			t.Fatalf("%s is too wide", line)
+		}
+	}
+
+	if strings.Join(lines, " ") != "the quick brown fox jumps over the lazy dog" {
+		t.Fatalf("Unexpected lines %v", lines)
+	}
+}
+
+func TestPdfMethodology(t *testing.T) {
+	defer func(chain *PriceProviderChain, store *L1RewardStore) {
+		defaultPriceProviders, l1RewardStore = chain, store
+	}(defaultPriceProviders, l1RewardStore)
+
+	data := []DataPoint{{Date: "2022-05-01", Token: "hnt", PriceSource: "cryptocompare"}}
+
+	defaultPriceProviders = newPriceProviderChain("cryptocompare")
+	l1RewardStore = &L1RewardStore{t.TempDir()}
+
+	// a year before the migration, with a single provider and imported rewards
+	methodology := pdfMethodology(2022, data)
+
+	if !strings.HasPrefix(methodology[0], "Each token's daily GBP price is taken from CryptoCompare. The prices in this report came from CryptoCompare.") ||
+		!strings.Contains(methodology[0], "Rewards come from Helium ETL dumps and archived Helium API pages.") ||
+		strings.Contains(methodology[0], "CoinGecko") || strings.Contains(methodology[0], "Solana") {
+		t.Fatalf("Unexpected methodology %s", methodology[0])
+	}
+
+	defaultPriceProviders = newPriceProviderChain("coingecko,cryptocompare")
+	l1RewardStore = nil
+
+	// the year of the migration
+	methodology = pdfMethodology(2023, data)
+
+	if !strings.Contains(methodology[0], "the first of CoinGecko, then CryptoCompare to have it") ||
+		!strings.Contains(methodology[0], "come from the Helium API, and rewards after it from the wallet's reward claims on Solana") {
+		t.Fatalf("Unexpected methodology %s", methodology[0])
+	}
+
+	if methodology = pdfMethodology(2024, nil); !strings.Contains(methodology[0], "Rewards come from the wallet's reward claims on Solana.") || strings.Contains(methodology[0], "came from") {
+		t.Fatalf("Unexpected methodology %s", methodology[0])
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+type PriceStatus string
+
+const (
+	// a provider had the day's price
+	PriceExact PriceStatus = "exact"
+	// drawn on a straight line between the prices either side of the gap
+	PriceInterpolated PriceStatus = "interpolated"
+	// the last price before the gap
+	PriceCarriedForward PriceStatus = "carried-forward"
+	// no price, the day's earnings are reported as zero
+	PriceMissing PriceStatus = "missing"
+)
+
+type GapFillPolicy string
+
+const (
+	GapFillNone         GapFillPolicy = "none"
+	GapFillCarryForward GapFillPolicy = "carry-forward"
+	GapFillInterpolate  GapFillPolicy = "interpolate"
+)
+
+const DEFAULT_PRICE_GAP_MAX_DAYS = 7
+
+// interpolated prices are kept to a fraction of a penny
+const INTERPOLATED_PRICE_PLACES = 8
+
+/*
+ How days without a price are filled in. Gaps longer than MaxDays are
+ left missing, a guess that far from a real price isn't worth filing.
+ Interpolation needs a price after the gap, so a gap at the end of the
+ range is carried forward instead.
+*/
+type GapFilling struct {
+	Policy  GapFillPolicy
+	MaxDays int
+}
+
+var defaultGapFilling = newGapFilling(os.Getenv("PRICE_GAP_POLICY"), os.Getenv("PRICE_GAP_MAX_DAYS"))
+
+func newGapFilling(policy string, maxDays string) GapFilling {
+	filling := GapFilling{GapFillInterpolate, DEFAULT_PRICE_GAP_MAX_DAYS}
+
+	switch GapFillPolicy(policy) {
+	case "":
+	case GapFillNone, GapFillCarryForward, GapFillInterpolate:
+		filling.Policy = GapFillPolicy(policy)
+	default:
+		log.Printf("Unknown price gap policy %s, using %s", policy, filling.Policy)
+	}
+
+	if maxDays != "" {
+		parsed, err := strconv.Atoi(maxDays)
+		if err != nil || parsed < 0 {
+			log.Printf("Invalid PRICE_GAP_MAX_DAYS %s, using %d", maxDays, filling.MaxDays)
+		} else {
+			filling.MaxDays = parsed
+		}
+	}
+
+	return filling
+}
+
+// returns a quote for every day, days that couldn't be filled are PriceMissing
+func (filling GapFilling) fill(quotes PriceQuotesByTime, days []time.Time) PriceQuotesByTime {
+	filled := make(PriceQuotesByTime)
+
+	for i := 0; i < len(days); i++ {
+		if quote, ok := quotes[days[i]]; ok {
+			filled[days[i]] = quote
+			continue
+		}
+
+		// the gap runs from i up to the next day with a price
+		gapEnd := i
+		for gapEnd < len(days) {
+			if _, ok := quotes[days[gapEnd]]; ok {
+				break
+			}
+			gapEnd++
+		}
+
+		var before, after *PriceQuote
+
+		if i > 0 {
+			quote := filled[days[i-1]]
+			before = &quote
+		}
+
+		if gapEnd < len(days) {
+			quote := quotes[days[gapEnd]]
+			after = &quote
+		}
+
+		gapLength := gapEnd - i
+		canFill := filling.Policy != GapFillNone && before != nil && gapLength <= filling.MaxDays
+
+		for j := i; j < gapEnd; j++ {
+			switch {
+			case !canFill:
+				filled[days[j]] = PriceQuote{Status: PriceMissing}
+			case filling.Policy == GapFillInterpolate && after != nil:
+				// before and after are gapLength + 1 days apart
+				step := after.Price.Sub(before.Price).Mul(NewDecimal(int64(j-i+1), 0))
+				price := before.Price.Add(step.Div(NewDecimal(int64(gapLength+1), 0), INTERPOLATED_PRICE_PLACES, DIVISION_ROUNDING))
+
+				filled[days[j]] = PriceQuote{price.normalize(), before.Source, PriceInterpolated}
+			default:
+				filled[days[j]] = PriceQuote{before.Price, before.Source, PriceCarriedForward}
+			}
+		}
+
+		i = gapEnd - 1
+	}
+
+	return filled
+}
+
+type ReportWarning struct {
+	Date        string      `json:"date"`
+	Token       string      `json:"token"`
+	PriceStatus PriceStatus `json:"price_status"`
+	Message     string      `json:"message"`
+}
+
+// a warning for every day whose earnings weren't valued at an exact price
+func priceWarnings(data []DataPoint) []ReportWarning {
+	warnings := []ReportWarning{}
+
+	for _, point := range data {
+		var message string
+
+		switch point.PriceStatus {
+		case PriceInterpolated:
+			message = "No price for this day, it was interpolated from the days either side"
+		case PriceCarriedForward:
+			message = "No price for this day, the previous day's price was used"
+		case PriceMissing:
+			message = "No price for this day, its earnings are reported as £0"
+		default:
+			continue
+		}
+
+		warnings = append(warnings, ReportWarning{point.Date, point.Token, point.PriceStatus, message})
+	}
+
+	return warnings
+}
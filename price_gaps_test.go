@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGapFilling(t *testing.T) {
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, reportLocation)
+	days := daysInRange(start, start.AddDate(0, 0, 8))
+
+	quotes := PriceQuotesByTime{
+		days[1]: {MustParseDecimal("2"), "coingecko", PriceExact},
+		days[4]: {MustParseDecimal("5"), "coingecko", PriceExact},
+		days[5]: {MustParseDecimal("6"), "cryptocompare", PriceExact},
+	}
+
+	filled := GapFilling{GapFillInterpolate, 7}.fill(quotes, days)
+
+	// nothing before the first price to fill from
+	if filled[days[0]].Status != PriceMissing {
+		t.Fatalf("Expected the first day to be missing %+v", filled[days[0]])
+	}
+
+	if filled[days[2]].Status != PriceInterpolated || filled[days[2]].Price.String() != "3" || filled[days[3]].Price.String() != "4" {
+		t.Fatalf("Unexpected interpolation %+v %+v", filled[days[2]], filled[days[3]])
+	}
+
+	// nothing after the last price, so it is carried forward
+	if filled[days[7]].Status != PriceCarriedForward || filled[days[7]].Price.String() != "6" || filled[days[7]].Source != "cryptocompare" {
+		t.Fatalf("Unexpected carry forward %+v", filled[days[7]])
+	}
+
+	carried := GapFilling{GapFillCarryForward, 7}.fill(quotes, days)
+	if carried[days[3]].Status != PriceCarriedForward || carried[days[3]].Price.String() != "2" {
+		t.Fatalf("Unexpected carry forward %+v", carried[days[3]])
+	}
+
+	// both gaps are two days long
+	limited := GapFilling{GapFillInterpolate, 1}.fill(quotes, days)
+	if limited[days[2]].Status != PriceMissing || limited[days[7]].Status != PriceMissing || limited[days[5]].Status != PriceExact {
+		t.Fatalf("Expected long gaps to be left missing %+v %+v", limited[days[2]], limited[days[7]])
+	}
+
+	none := GapFilling{GapFillNone, 7}.fill(quotes, days)
+	if none[days[2]].Status != PriceMissing || none[days[4]].Status != PriceExact {
+		t.Fatalf("Expected gaps to be left missing")
+	}
+}
+
+func TestPriceWarnings(t *testing.T) {
+	data := []DataPoint{
+		{Date: "2023-05-01", PriceStatus: PriceExact},
+		{Date: "2023-05-02", PriceStatus: PriceMissing},
+	}
+
+	warnings := priceWarnings(data)
+	if len(warnings) != 1 || warnings[0].Date != "2023-05-02" {
+		t.Fatalf("Unexpected warnings %+v", warnings)
+	}
+}
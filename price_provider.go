@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+type PriceQuote struct {
+	Price  Decimal
+	Source string
+	Status PriceStatus
+}
+
+type PriceQuotesByTime = map[time.Time]PriceQuote
+
+// a source of GBP prices, assets are the lower case ticker e.g. "hnt"
+type PriceProvider interface {
+	Name() string
+	// closing prices keyed by dateAtStartOfDay, days the provider doesn't have are left out
+	DailyPrices(asset string, startTime time.Time, endTime time.Time, cache Cache) (PricesBytime, error)
+	SpotPrice(asset string, cache Cache) (float64, error)
+	SupportedAssets() []string
+}
+
+/*
+ Asks each provider in turn, the first provider to return a day's price
+ wins and later providers only fill in the days that are still missing.
+*/
+type PriceProviderChain struct {
+	providers []PriceProvider
+}
+
+var priceProviderByName = map[string]PriceProvider{
+	"coingecko":     &CoinGeckoProvider{currency: "gbp"},
+	"cryptocompare": &CryptoCompareProvider{currency: "GBP"},
+}
+
+var defaultPriceProviders = newPriceProviderChain(os.Getenv("PRICE_PROVIDERS"))
+
+// names is a comma separated list in order of preference, e.g. "coingecko,cryptocompare"
+func newPriceProviderChain(names string) *PriceProviderChain {
+	if names == "" {
+		names = "coingecko,cryptocompare"
+	}
+
+	chain := PriceProviderChain{}
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		provider, ok := priceProviderByName[name]
+
+		if !ok {
+			log.Printf("Unknown price provider %s, ignoring", name)
+			continue
+		}
+
+		chain.providers = append(chain.providers, provider)
+	}
+
+	return &chain
+}
+
+func supportsAsset(provider PriceProvider, asset string) bool {
+	for _, supported := range provider.SupportedAssets() {
+		if supported == asset {
+			return true
+		}
+	}
+
+	return false
+}
+
+func daysInRange(startTime time.Time, endTime time.Time) []time.Time {
+	var days []time.Time
+
+	for day := dateAtStartOfDay(startTime); day.Before(endTime); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+
+	return days
+}
+
+func (chain *PriceProviderChain) DailyQuotes(asset string, startTime time.Time, endTime time.Time, cache Cache) (PriceQuotesByTime, error) {
+	asset = strings.ToLower(asset)
+	quotes := make(PriceQuotesByTime)
+	days := daysInRange(startTime, endTime)
+
+	var errs []string
+
+	for _, provider := range chain.providers {
+		if len(quotes) >= len(days) {
+			break
+		}
+
+		if !supportsAsset(provider, asset) {
+			continue
+		}
+
+		prices, err := provider.DailyPrices(asset, startTime, endTime, cache)
+		if err != nil {
+			log.Printf("Price provider %s failed for %s %s", provider.Name(), asset, err)
+			errs = append(errs, fmt.Sprintf("%s: %s", provider.Name(), err))
+			continue
+		}
+
+		for _, day := range days {
+			if _, ok := quotes[day]; ok {
+				continue
+			}
+
+			if price, ok := prices[day]; ok {
+				quotes[day] = PriceQuote{NewDecimalFromFloat(price), provider.Name(), PriceExact}
+			}
+		}
+	}
+
+	if len(quotes) == 0 && len(errs) > 0 {
+		return quotes, fmt.Errorf("no prices for %s, %s", asset, strings.Join(errs, ", "))
+	}
+
+	return quotes, nil
+}
+
+func (chain *PriceProviderChain) SpotPrice(asset string, cache Cache) (float64, string, error) {
+	var errs []string
+
+	// spot prices are looked up by any ticker, so every provider gets a go
+	for _, provider := range chain.providers {
+		price, err := provider.SpotPrice(asset, cache)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", provider.Name(), err))
+			continue
+		}
+
+		return price, provider.Name(), nil
+	}
+
+	return 0, "", fmt.Errorf("no price for %s, %s", asset, strings.Join(errs, ", "))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type stubPriceProvider struct {
+	name   string
+	assets []string
+	prices PricesBytime
+	spot   float64
+	err    error
+	calls  int
+}
+
+func (provider *stubPriceProvider) Name() string {
+	return provider.name
+}
+
+func (provider *stubPriceProvider) DailyPrices(asset string, startTime time.Time, endTime time.Time, cache Cache) (PricesBytime, error) {
+	provider.calls++
+	return provider.prices, provider.err
+}
+
+func (provider *stubPriceProvider) SpotPrice(asset string, cache Cache) (float64, error) {
+	provider.calls++
+	return provider.spot, provider.err
+}
+
+func (provider *stubPriceProvider) SupportedAssets() []string {
+	return provider.assets
+}
+
+func TestPriceProviderChainDailyQuotes(t *testing.T) {
+	start := dateAtStartOfDay(time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC))
+	end := start.AddDate(0, 0, 3)
+	days := daysInRange(start, end)
+
+	prices := func(values ...float64) PricesBytime {
+		byDay := make(PricesBytime)
+
+		for i, value := range values {
+			if value != 0 {
+				byDay[days[i]] = value
+			}
+		}
+
+		return byDay
+	}
+
+	failure := errors.New("rate limited")
+
+	tests := []struct {
+		name      string
+		first     *stubPriceProvider
+		second    *stubPriceProvider
+		sources   []string
+		prices    []string
+		secondRan bool
+		err       bool
+	}{
+		{
+			name:    "the first provider has every day",
+			first:   &stubPriceProvider{name: "first", assets: []string{"hnt"}, prices: prices(1, 2, 3)},
+			second:  &stubPriceProvider{name: "second", assets: []string{"hnt"}, prices: prices(9, 9, 9)},
+			sources: []string{"first", "first", "first"},
+			prices:  []string{"1", "2", "3"},
+		},
+		{
+			name:      "the first provider fails",
+			first:     &stubPriceProvider{name: "first", assets: []string{"hnt"}, err: failure},
+			second:    &stubPriceProvider{name: "second", assets: []string{"hnt"}, prices: prices(4, 5, 6)},
+			sources:   []string{"second", "second", "second"},
+			prices:    []string{"4", "5", "6"},
+			secondRan: true,
+		},
+		{
+			name:      "the first provider has nothing",
+			first:     &stubPriceProvider{name: "first", assets: []string{"hnt"}, prices: prices()},
+			second:    &stubPriceProvider{name: "second", assets: []string{"hnt"}, prices: prices(4, 5, 6)},
+			sources:   []string{"second", "second", "second"},
+			prices:    []string{"4", "5", "6"},
+			secondRan: true,
+		},
+		{
+			name:      "the second provider only fills the gaps",
+			first:     &stubPriceProvider{name: "first", assets: []string{"hnt"}, prices: prices(1, 0, 3)},
+			second:    &stubPriceProvider{name: "second", assets: []string{"hnt"}, prices: prices(7, 8, 9)},
+			sources:   []string{"first", "second", "first"},
+			prices:    []string{"1", "8", "3"},
+			secondRan: true,
+		},
+		{
+			name:      "the first provider doesn't have the asset",
+			first:     &stubPriceProvider{name: "first", assets: []string{"iot"}, prices: prices(1, 2, 3)},
+			second:    &stubPriceProvider{name: "second", assets: []string{"hnt"}, prices: prices(4, 5, 6)},
+			sources:   []string{"second", "second", "second"},
+			prices:    []string{"4", "5", "6"},
+			secondRan: true,
+		},
+		{
+			name:      "every provider fails",
+			first:     &stubPriceProvider{name: "first", assets: []string{"hnt"}, err: failure},
+			second:    &stubPriceProvider{name: "second", assets: []string{"hnt"}, err: failure},
+			secondRan: true,
+			err:       true,
+		},
+	}
+
+	for _, test := range tests {
+		chain := PriceProviderChain{[]PriceProvider{test.first, test.second}}
+
+		quotes, err := chain.DailyQuotes("HNT", start, end, newMemoryCache(10))
+
+		if (err != nil) != test.err {
+			t.Fatalf("%s: unexpected error %v", test.name, err)
+		}
+
+		if (test.second.calls > 0) != test.secondRan {
+			t.Fatalf("%s: the second provider was called %d times", test.name, test.second.calls)
+		}
+
+		if len(quotes) != len(test.sources) {
+			t.Fatalf("%s: unexpected quotes %+v", test.name, quotes)
+		}
+
+		for i, source := range test.sources {
+			quote := quotes[days[i]]
+
+			if quote.Source != source || quote.Price.String() != test.prices[i] || quote.Status != PriceExact {
+				t.Fatalf("%s: unexpected quote for %s %+v", test.name, days[i], quote)
+			}
+		}
+	}
+}
+
+func TestPriceProviderChainSpotPrice(t *testing.T) {
+	failure := errors.New("rate limited")
+
+	tests := []struct {
+		name   string
+		first  *stubPriceProvider
+		second *stubPriceProvider
+		price  float64
+		source string
+		err    bool
+	}{
+		{
+			name:   "the first provider answers",
+			first:  &stubPriceProvider{name: "first", spot: 1.5},
+			second: &stubPriceProvider{name: "second", spot: 2.5},
+			price:  1.5,
+			source: "first",
+		},
+		{
+			name:   "the first provider fails",
+			first:  &stubPriceProvider{name: "first", err: failure},
+			second: &stubPriceProvider{name: "second", spot: 2.5},
+			price:  2.5,
+			source: "second",
+		},
+		{
+			name:   "every provider fails",
+			first:  &stubPriceProvider{name: "first", err: failure},
+			second: &stubPriceProvider{name: "second", err: failure},
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		chain := PriceProviderChain{[]PriceProvider{test.first, test.second}}
+
+		price, source, err := chain.SpotPrice("hnt", newMemoryCache(10))
+
+		if (err != nil) != test.err || price != test.price || source != test.source {
+			t.Fatalf("%s: unexpected %f %s %v", test.name, price, source, err)
+		}
+	}
+}
+
+func TestNewPriceProviderChain(t *testing.T) {
+	chain := newPriceProviderChain(" CryptoCompare, unknown ,coingecko")
+
+	if len(chain.providers) != 2 || chain.providers[0].Name() != "cryptocompare" || chain.providers[1].Name() != "coingecko" {
+		t.Fatalf("Unexpected providers %+v", chain.providers)
+	}
+}
+
+func TestCryptoCompareDailyPricesUseOpen(t *testing.T) {
+	provider := &CryptoCompareProvider{currency: "GBP"}
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 2)
+	cache := newMemoryCache(10)
+
+	// the url is the cache key, so the response can be put there in place of the api
+	url := fmt.Sprintf("https://min-api.cryptocompare.com/data/v2/histoday?fsym=HNT&tsym=GBP&limit=%d&toTs=%d", len(daysInRange(start, end)), end.Unix())
+	cache.Set(url, fmt.Sprintf(`{"Response": "Success", "Data": {"Data": [
+		{"time": %d, "open": 0, "close": 0},
+		{"time": %d, "open": 2.5, "close": 3}
+	]}}`, start.AddDate(0, 0, -1).Unix(), start.Unix()), 0)
+
+	prices, err := provider.DailyPrices("hnt", start, end, cache)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	// the close is the next midnight's price, the day's own starts at its open
+	if len(prices) != 1 || prices[dateAtStartOfDay(start)] != 2.5 {
+		t.Fatalf("Unexpected prices %v", prices)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const MAX_RETRIES = 4
+const RETRY_BASE_DELAY = 1 * time.Second
+const RETRY_MAX_DELAY = 60 * time.Second
+const UPSTREAM_DEADLINE = 5 * time.Minute
+
+type RateLimit struct {
+	PerSecond float64
+	Burst     float64
+}
+
+// roughly what each upstream tolerates from a single IP on its free tier
+var defaultRateLimits = map[string]RateLimit{
+	"api.helium.io":               {PerSecond: 4, Burst: 4},
+	"api.coingecko.com":           {PerSecond: 0.2, Burst: 5},
+	"min-api.cryptocompare.com":   {PerSecond: 1, Burst: 5},
+	"api.mainnet-beta.solana.com": {PerSecond: 4, Burst: 10},
+}
+
+var fallbackRateLimit = RateLimit{PerSecond: 4, Burst: 4}
+
+// a token bucket, every request takes a token and they refill at a fixed rate
+type TokenBucket struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *TokenBucket {
+	return &TokenBucket{limit: limit, tokens: limit.Burst, last: time.Now()}
+}
+
+// reserves a token, returning how long to wait before it can be used
+func (bucket *TokenBucket) reserve() time.Duration {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.last = now
+
+	bucket.tokens = math.Min(bucket.limit.Burst, bucket.tokens+elapsed*bucket.limit.PerSecond)
+	bucket.tokens--
+
+	if bucket.tokens >= 0 {
+		return 0
+	}
+
+	// the bucket is in debt, wait until our token has been refilled
+	return time.Duration(-bucket.tokens / bucket.limit.PerSecond * float64(time.Second))
+}
+
+type HostRateLimiter struct {
+	mu      sync.Mutex
+	limits  map[string]RateLimit
+	buckets map[string]*TokenBucket
+}
+
+var upstreamRateLimiter = newHostRateLimiter(os.Getenv("RATE_LIMITS"))
+
+/*
+ config overrides the defaults per host, as a comma separated list of
+ host=requests per second/burst e.g. "api.coingecko.com=0.5/10,api.helium.io=2/2"
+*/
+func newHostRateLimiter(config string) *HostRateLimiter {
+	limiter := HostRateLimiter{
+		limits:  make(map[string]RateLimit),
+		buckets: make(map[string]*TokenBucket),
+	}
+
+	for host, limit := range defaultRateLimits {
+		limiter.limits[host] = limit
+	}
+
+	overrides, err := parseRateLimits(config)
+	if err != nil {
+		log.Printf("Ignoring rate limits %s", err)
+	}
+
+	for host, limit := range overrides {
+		limiter.limits[host] = limit
+	}
+
+	return &limiter
+}
+
+func parseRateLimits(config string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		host, value, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("%s is not host=rate/burst", entry)
+		}
+
+		rate, burst, _ := strings.Cut(value, "/")
+
+		perSecond, err := strconv.ParseFloat(rate, 64)
+		if err != nil || perSecond <= 0 {
+			return nil, fmt.Errorf("%s has an invalid rate", entry)
+		}
+
+		limit := RateLimit{PerSecond: perSecond, Burst: math.Max(1, perSecond)}
+
+		if burst != "" {
+			limit.Burst, err = strconv.ParseFloat(burst, 64)
+			if err != nil || limit.Burst < 1 {
+				return nil, fmt.Errorf("%s has an invalid burst", entry)
+			}
+		}
+
+		limits[strings.ToLower(strings.TrimSpace(host))] = limit
+	}
+
+	return limits, nil
+}
+
+func (limiter *HostRateLimiter) bucketFor(host string) *TokenBucket {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	host = strings.ToLower(host)
+
+	if bucket, ok := limiter.buckets[host]; ok {
+		return bucket
+	}
+
+	limit, ok := limiter.limits[host]
+	if !ok {
+		limit = fallbackRateLimit
+	}
+
+	bucket := newTokenBucket(limit)
+	limiter.buckets[host] = bucket
+
+	return bucket
+}
+
+// blocks until the host has capacity for another request
+func (limiter *HostRateLimiter) wait(req *http.Request) error {
+	delay := limiter.bucketFor(req.URL.Hostname()).reserve()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	return sleepWithContext(req, delay)
+}
+
+func sleepWithContext(req *http.Request, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// Retry-After is either a number of seconds or a http date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+
+	return 0, false
+}
+
+// exponential backoff with full jitter
+func backoffDelay(attempt int) time.Duration {
+	ceiling := math.Min(float64(RETRY_MAX_DELAY), float64(RETRY_BASE_DELAY)*math.Pow(2, float64(attempt)))
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+/*
+ Sits underneath every upstream http client, so both our own requests and
+ the solana rpc client's are limited per host and retried with backoff.
+*/
+type upstreamTransport struct {
+	base    http.RoundTripper
+	limiter *HostRateLimiter
+}
+
+func (transport *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		err := transport.limiter.wait(req)
+		if err != nil {
+			return nil, err
+		}
+
+		// the body has been consumed by the previous attempt
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		res, err := transport.base.RoundTrip(req)
+
+		canRetry := attempt < MAX_RETRIES && (req.Body == nil || req.GetBody != nil)
+
+		if err != nil {
+			if !canRetry || req.Context().Err() != nil {
+				return nil, err
+			}
+
+			delay := backoffDelay(attempt)
+			log.Printf("%s %s, retrying in %s", req.URL.Host, err, delay)
+
+			if sleepErr := sleepWithContext(req, delay); sleepErr != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !isRetryableStatus(res.StatusCode) || !canRetry {
+			return res, nil
+		}
+
+		delay := backoffDelay(attempt)
+
+		// the upstream knows best when it will have capacity again
+		if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable {
+			if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok && retryAfter > delay {
+				delay = retryAfter
+			}
+		}
+
+		res.Body.Close()
+		log.Printf("%d - %s, retrying in %s", res.StatusCode, req.URL, delay)
+
+		if sleepErr := sleepWithContext(req, delay); sleepErr != nil {
+			return nil, sleepErr
+		}
+	}
+}
+
+/*
+ attemptTimeout bounds a single attempt, the client's own timeout has to
+ leave room for every retry and the backoff between them.
+*/
+func newUpstreamHttpClient(attemptTimeout time.Duration) *http.Client {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.ResponseHeaderTimeout = attemptTimeout
+
+	return &http.Client{
+		Timeout: UPSTREAM_DEADLINE,
+		Transport: &upstreamTransport{
+			base:    base,
+			limiter: upstreamRateLimiter,
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits("api.coingecko.com=0.5/10, api.helium.io=2")
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	if limits["api.coingecko.com"] != (RateLimit{0.5, 10}) || limits["api.helium.io"] != (RateLimit{2, 2}) {
+		t.Fatalf("Unexpected limits %v", limits)
+	}
+
+	_, err = parseRateLimits("api.helium.io")
+	if err == nil {
+		t.Fatalf("Expected an invalid config")
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(RateLimit{PerSecond: 1, Burst: 2})
+
+	if bucket.reserve() != 0 || bucket.reserve() != 0 {
+		t.Fatalf("Expected the burst to be free")
+	}
+
+	if delay := bucket.reserve(); delay < 900*time.Millisecond {
+		t.Fatalf("Expected to wait for a token, got %s", delay)
+	}
+}
+
+func TestUpstreamTransportRetries(t *testing.T) {
+	attempts := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &upstreamTransport{
+			base:    http.DefaultTransport,
+			limiter: newHostRateLimiter(""),
+		},
+	}
+
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || attempts != 2 {
+		t.Fatalf("Expected a single retry, got %d attempts and %d", attempts, res.StatusCode)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"time"
+)
+
+// average prices are kept to a fraction of a penny
+const AVERAGE_PRICE_PLACES = 8
+
+// renders a whole report as a downloadable file
+type reportWriter struct {
+	contentType string
+	write       func(w io.Writer, address string, taxYear int, data []DataPoint, generatedAt time.Time) error
+}
+
+// keyed by the extension on /report/:address
+var reportWriters = map[string]reportWriter{
+	"xlsx": {XLSX_CONTENT_TYPE, writeReportXLSX},
+	"pdf":  {PDF_CONTENT_TYPE, writeReportPDF},
+}
+
+// a month's income across every token, with the tokens earned keyed by token
+type MonthSummary struct {
+	Month       string             `json:"month"`
+	Income      Decimal            `json:"income"`
+	Tokens      map[string]Decimal `json:"tokens"`
+	EarningDays int                `json:"earning_days"`
+}
+
+type TokenSummary struct {
+	Token       string  `json:"token"`
+	Tokens      Decimal `json:"tokens"`
+	Income      Decimal `json:"income"`
+	EarningDays int     `json:"earning_days"`
+	// income over tokens, i.e. weighted by the tokens earned each day we have a price for
+	AveragePrice Decimal `json:"average_price"`
+}
+
+/*
+ The numbers a user files. Totals are summed exactly and only rounded to
+ pence at the end, each month and token is rounded on its own so they can
+ be a penny or two out from the total. Tokens can't be added together, so
+ they are only totalled per token.
+*/
+type ReportSummary struct {
+	TaxYear          int            `json:"tax_year"`
+	TotalIncome      Decimal        `json:"total_income"`
+	TotalIncomeExact Decimal        `json:"total_income_exact"`
+	EarningDays      int            `json:"earning_days"`
+	FirstReward      string         `json:"first_reward,omitempty"`
+	LastReward       string         `json:"last_reward,omitempty"`
+	Tokens           []TokenSummary `json:"tokens"`
+	Months           []MonthSummary `json:"months"`
+}
+
+// the only token's summary, when everything was earned in one token
+func (summary ReportSummary) singleToken() (TokenSummary, bool) {
+	if len(summary.Tokens) != 1 {
+		return TokenSummary{}, false
+	}
+
+	return summary.Tokens[0], true
+}
+
+func summariseReport(taxYear int, data []DataPoint) ReportSummary {
+	summary := ReportSummary{TaxYear: taxYear, Tokens: []TokenSummary{}, Months: []MonthSummary{}}
+	byMonth := make(map[string]*MonthSummary)
+	byToken := make(map[string]*TokenSummary)
+	earntOn := make(map[string]bool)
+	// days without a price have no income, so their tokens are left out of the average
+	pricedTokens := make(map[string]Decimal)
+
+	for _, point := range data {
+		if point.Tokens.Sign() <= 0 {
+			continue
+		}
+
+		summary.TotalIncomeExact = summary.TotalIncomeExact.Add(point.Earnings)
+
+		if summary.FirstReward == "" || point.Date < summary.FirstReward {
+			summary.FirstReward = point.Date
+		}
+
+		if point.Date > summary.LastReward {
+			summary.LastReward = point.Date
+		}
+
+		if _, ok := byToken[point.Token]; !ok {
+			byToken[point.Token] = &TokenSummary{Token: point.Token}
+		}
+
+		byToken[point.Token].Tokens = byToken[point.Token].Tokens.Add(point.Tokens)
+		byToken[point.Token].Income = byToken[point.Token].Income.Add(point.Earnings)
+		byToken[point.Token].EarningDays++
+
+		if point.PriceStatus != PriceMissing {
+			pricedTokens[point.Token] = pricedTokens[point.Token].Add(point.Tokens)
+		}
+
+		month := point.Date[:7]
+
+		if _, ok := byMonth[month]; !ok {
+			byMonth[month] = &MonthSummary{Month: month, Tokens: make(map[string]Decimal)}
+		}
+
+		byMonth[month].Income = byMonth[month].Income.Add(point.Earnings)
+		byMonth[month].Tokens[point.Token] = byMonth[month].Tokens[point.Token].Add(point.Tokens)
+
+		// a day is counted once however many tokens were earned on it
+		if !earntOn[point.Date] {
+			earntOn[point.Date] = true
+			summary.EarningDays++
+			byMonth[month].EarningDays++
+		}
+	}
+
+	for _, month := range byMonth {
+		month.Income = month.Income.RoundGBP()
+		summary.Months = append(summary.Months, *month)
+	}
+
+	sort.SliceStable(summary.Months, func(i, j int) bool {
+		return summary.Months[i].Month < summary.Months[j].Month
+	})
+
+	for _, token := range byToken {
+		if priced := pricedTokens[token.Token]; priced.Sign() > 0 {
+			token.AveragePrice = token.Income.Div(priced, AVERAGE_PRICE_PLACES, DIVISION_ROUNDING).normalize()
+		}
+		token.Income = token.Income.RoundGBP()
+		summary.Tokens = append(summary.Tokens, *token)
+	}
+
+	sort.SliceStable(summary.Tokens, func(i, j int) bool {
+		return tokenIndex(summary.Tokens[i].Token) < tokenIndex(summary.Tokens[j].Token)
+	})
+
+	summary.TotalIncome = summary.TotalIncomeExact.RoundGBP()
+
+	return summary
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSummariseReport(t *testing.T) {
+	data := []DataPoint{
+		{Date: "2023-04-30", Token: "hnt", Earnings: d("1.005"), Tokens: d("0.5"), Price: d("2.01")},
+		{Date: "2023-05-01", Token: "hnt", Earnings: d("0"), Tokens: d("0")},
+		{Date: "2023-05-02", Token: "hnt", Earnings: d("3.004"), Tokens: d("1.5"), Price: d("2.00266666")},
+		{Date: "2023-05-02", Token: "iot", Earnings: d("0.25"), Tokens: d("500"), Price: d("0.0005")},
+	}
+
+	summary := summariseReport(2023, data)
+
+	if summary.TotalIncome.String() != "4.26" || summary.TotalIncomeExact.String() != "4.259" {
+		t.Fatalf("Unexpected totals %+v", summary)
+	}
+
+	// the 2nd of May is one earning day, though both tokens were earned on it
+	if summary.EarningDays != 2 || summary.FirstReward != "2023-04-30" || summary.LastReward != "2023-05-02" {
+		t.Fatalf("Unexpected days %+v", summary)
+	}
+
+	if len(summary.Tokens) != 2 || summary.Tokens[0].Token != "hnt" || summary.Tokens[1].Token != "iot" {
+		t.Fatalf("Unexpected tokens %+v", summary.Tokens)
+	}
+
+	hnt, iot := summary.Tokens[0], summary.Tokens[1]
+
+	if hnt.Tokens.String() != "2.0" || hnt.Income.String() != "4.01" || hnt.AveragePrice.String() != "2.0045" || hnt.EarningDays != 2 {
+		t.Fatalf("Unexpected HNT summary %+v", hnt)
+	}
+
+	if iot.Tokens.String() != "500" || iot.Income.String() != "0.25" || iot.AveragePrice.String() != "0.0005" {
+		t.Fatalf("Unexpected IOT summary %+v", iot)
+	}
+
+	// each month is rounded on its own
+	if len(summary.Months) != 2 || summary.Months[0].Income.String() != "1.01" || summary.Months[1].Income.String() != "3.25" {
+		t.Fatalf("Unexpected months %+v", summary.Months)
+	}
+
+	if summary.Months[1].Tokens["hnt"].String() != "1.5" || summary.Months[1].Tokens["iot"].String() != "500" || summary.Months[1].EarningDays != 1 {
+		t.Fatalf("Unexpected month tokens %+v", summary.Months[1])
+	}
+
+	empty := summariseReport(2023, nil)
+	if empty.TotalIncome.String() != "0.00" || empty.EarningDays != 0 || len(empty.Months) != 0 {
+		t.Fatalf("Unexpected empty summary %+v", empty)
+	}
+}
+
+func TestSummariseReportAveragePriceSkipsMissingDays(t *testing.T) {
+	data := []DataPoint{
+		{Date: "2023-05-01", Token: "hnt", Earnings: d("4"), Tokens: d("2"), Price: d("2"), PriceStatus: PriceExact},
+		{Date: "2023-05-02", Token: "hnt", Earnings: d("3"), Tokens: d("1"), Price: d("3"), PriceStatus: PriceInterpolated},
+		// no price, so no income either
+		{Date: "2023-05-03", Token: "hnt", Tokens: d("5"), PriceStatus: PriceMissing},
+		{Date: "2023-05-03", Token: "iot", Tokens: d("100"), PriceStatus: PriceMissing},
+	}
+
+	summary := summariseReport(2023, data)
+	hnt, iot := summary.Tokens[0], summary.Tokens[1]
+
+	// the missing day's tokens are still earned, but don't drag the average down
+	if hnt.Tokens.String() != "8" || hnt.Income.String() != "7.00" || hnt.AveragePrice.String() != "2.33333333" || hnt.EarningDays != 3 {
+		t.Fatalf("Unexpected HNT summary %+v", hnt)
+	}
+
+	if iot.Tokens.String() != "100" || !iot.AveragePrice.IsZero() {
+		t.Fatalf("Unexpected IOT summary %+v", iot)
+	}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"log"
-	"math"
+	"math/big"
+	"sort"
 	"strconv"
+	"strings"
 
-	"github.com/memcachier/mc"
+	"github.com/mr-tron/base58"
 	"github.com/portto/solana-go-sdk/client"
 	"github.com/portto/solana-go-sdk/common"
-	"github.com/portto/solana-go-sdk/program/token"
 	"github.com/portto/solana-go-sdk/rpc"
 )
 
@@ -20,57 +24,134 @@ var addressByToken = map[string]string{
 	"mobile": "mb1eu7TzEc71KxDpsmsKoucSSuuoGLv1drys1oP2jh6",
 }
 
+// a mint's decimals can never change, so they are cached without expiry
+const MINT_DECIMALS_CACHE_TTL = 0
+
+// a lamport is 10^-9 SOL
+const SOL_DECIMALS = 9
+
+// mints made by the newer token program, its accounts aren't listed with the original's
+const TOKEN_2022_PROGRAM_ID = "TokenzQdBNbLqP5VEhdkAS6EPFLC1PHnBqCXEpPxuEb"
+
+func fetchSolanaAccountBalance(address string, token string, cache Cache) (Decimal, error) {
+	cacheKey := fmt.Sprintf("v3-%s-%s", address, token)
+
+	cachedData, cacheReadErr := cache.Get(cacheKey)
+
+	if cacheReadErr == nil {
+		log.Printf("[fetchSolanaAccountBalance] Cache hit %s", cacheKey)
+		return ParseDecimal(cachedData)
+	}
+
+	log.Printf("[fetchSolanaAccountBalance] Cache Miss %s", cacheKey)
+
+	balanceValue, err := fetchSolanaAccountBalanceInternal(address, token, cache)
+	if err != nil {
+		return Decimal{}, err
+	}
+
+	cache.Set(cacheKey, balanceValue.String(), RESULT_CACHE_TTL)
+
+	return balanceValue, nil
+}
+
 /*
- We have to do this weirdness as this Go lib
- doesn't provide the 'decimals' field
- https://docs.solana.com/api/http#token-balances-structure
+ config adds to or overrides the friendly symbols we accept in place of a
+ mint address, as a comma separated list e.g. "jup=JUPyiwrYJFskUPiHa7hkeR8VUtAeFoSYbKedZNsDvCN"
 */
-var divisorByToken = map[string]float64{
-	"iotEVVZLEywoTn1QdwNPddxPWszn3zFhEot3MfL9fns": math.Pow(10, 6),
-	"hntyVP6YFm1Hg25TN9WGLqM12b8TQmcknKrdu1oxWux": math.Pow(10, 8),
+func registerTokens(config string) error {
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		symbol, mint, found := strings.Cut(entry, "=")
+		if !found || !isSolanaAddress(strings.TrimSpace(mint)) {
+			return fmt.Errorf("%s is not symbol=mint", entry)
+		}
+
+		addressByToken[strings.ToLower(strings.TrimSpace(symbol))] = strings.TrimSpace(mint)
+	}
+
+	return nil
+}
+
+func isSolanaAddress(address string) bool {
+	decoded, err := base58.Decode(address)
+
+	return err == nil && len(decoded) == 32
 }
 
-type BalanceResponse struct {
-	balance float64
+// accepts either a symbol from the registry or any mint address
+func resolveTokenMint(token string) (string, error) {
+	if mint, ok := addressByToken[strings.ToLower(token)]; ok {
+		return mint, nil
+	}
+
+	if isSolanaAddress(token) {
+		return token, nil
+	}
+
+	var symbols []string
+	for symbol := range addressByToken {
+		symbols = append(symbols, strings.ToUpper(symbol))
+	}
+	sort.Strings(symbols)
+
+	return "", fmt.Errorf("Unknown token %s, use a mint address or one of SOL,%s", token, strings.Join(symbols, ","))
 }
 
-func fetchSolanaAccountBalance(address string, token string, cache *mc.Client) (float64, error) {
-	cacheKey := fmt.Sprintf("v2-%s-%s", address, token)
+func fetchMintDecimals(mint string, cache Cache) (uint8, error) {
+	cacheKey := fmt.Sprintf("v1-mint-decimals-%s", mint)
 
-	cachedData, _, _, cacheReadErr := cache.Get(cacheKey)
+	cachedData, cacheReadErr := cache.Get(cacheKey)
 
 	if cacheReadErr == nil {
-		log.Printf("[fetchSolanaAccountBalance] Cache hit %s", cacheKey)
-		return strconv.ParseFloat(cachedData, 64)
+		decimals, err := strconv.ParseUint(cachedData, 10, 8)
+		if err == nil {
+			return uint8(decimals), nil
+		}
 	}
 
-	log.Printf("[fetchSolanaAccountBalance] Cache Miss %s", cacheKey)
+	c := &client.Client{RpcClient: newSolanaRpcClient()}
 
-	balanceValue, err := fetchSolanaAccountBalanceInternal(address, token)
+	// the supply comes back with the mint's decimals, for both token programs
+	_, decimals, err := c.GetTokenSupply(context.TODO(), mint)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read mint %s: %s", mint, err)
+	}
 
-	cache.Set(cacheKey, fmt.Sprintf("%.4f", balanceValue), 0, RESULT_CACHE_TTL, 0)
+	cacheWriteErr := cache.Set(cacheKey, strconv.Itoa(int(decimals)), MINT_DECIMALS_CACHE_TTL)
+	if cacheWriteErr != nil {
+		log.Printf("Failed to cache %s %s", cacheKey, cacheWriteErr)
+	}
 
-	return balanceValue, err
+	return decimals, nil
 }
 
-func fetchSolanaAccountBalanceInternal(address string, token string) (float64, error) {
-	if token == "sol" {
+func fetchSolanaAccountBalanceInternal(address string, token string, cache Cache) (Decimal, error) {
+	if strings.ToLower(token) == "sol" {
 		log.Printf("[fetchSolanaAccountBalanceInternal] fetching SOL balance %s %s", address, token)
 		return fetchSolanaBalance(address)
 	}
 
-	tokenAddress := addressByToken[token]
+	tokenAddress, err := resolveTokenMint(token)
+	if err != nil {
+		return Decimal{}, err
+	}
 
-	if tokenAddress == "" {
-		return 0, fmt.Errorf("Unknown token, only HNT,IOT are supported atm")
+	decimals, err := fetchMintDecimals(tokenAddress, cache)
+	if err != nil {
+		return Decimal{}, err
 	}
 
 	log.Printf("[fetchSolanaAccountBalanceInternal] fetching SPL balance %s %s", address, token)
-	return fetchSPLBalance(address, tokenAddress)
+	return fetchSPLBalance(address, tokenAddress, decimals)
 }
 
-func fetchSolanaBalance(address string) (float64, error) {
-	c := client.NewClient(rpc.MainnetRPCEndpoint)
+func fetchSolanaBalance(address string) (Decimal, error) {
+	c := &client.Client{RpcClient: newSolanaRpcClient()}
 
 	balance, err := c.GetBalance(
 		context.TODO(),
@@ -78,39 +159,91 @@ func fetchSolanaBalance(address string) (float64, error) {
 	)
 
 	if err != nil {
-		return 0, err
+		return Decimal{}, err
 	}
 
-	return float64(balance) / math.Pow(10, 9), nil
+	return NewDecimalFromBaseUnits(int64(balance), SOL_DECIMALS), nil
 }
 
-func filterAccountsByToken(accounts map[common.PublicKey]token.TokenAccount, tokenAddress string) (token.TokenAccount, error) {
-	for pk := range accounts {
-		if accounts[pk].Mint.String() == tokenAddress {
-			return accounts[pk], nil
-		}
+// decodes an account's data from a base64 encoded rpc response
+func accountData(account rpc.AccountInfo) ([]byte, error) {
+	encoded, ok := account.Data.([]any)
+	if !ok || len(encoded) < 1 {
+		return nil, fmt.Errorf("unexpected account data %v", account.Data)
+	}
+
+	data, ok := encoded[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected account data %v", account.Data)
 	}
 
-	return token.TokenAccount{}, fmt.Errorf("Unable to find token on account")
+	return base64.StdEncoding.DecodeString(data)
 }
 
-func fetchSPLBalance(address string, tokenAddress string) (float64, error) {
-	c := client.NewClient(rpc.MainnetRPCEndpoint)
+/*
+ Both token programs start an account with the mint, the owner and the
+ amount, Token-2022 adds its extensions after, so the first 72 bytes are
+ all we need. The wallet can hold the mint in more than one account.
+*/
+func sumTokenAccounts(accounts [][]byte, tokenAddress string) (*big.Int, error) {
+	mint, err := base58.Decode(tokenAddress)
+	if err != nil {
+		return nil, err
+	}
 
-	accounts, err := c.GetTokenAccountsByOwner(
-		context.TODO(),
-		address,
-	)
+	total := new(big.Int)
 
-	if err != nil {
-		return 0, err
+	for _, data := range accounts {
+		if len(data) < 72 {
+			return nil, fmt.Errorf("token account is only %d bytes", len(data))
+		}
+
+		if bytes.Equal(data[:32], mint) {
+			total.Add(total, new(big.Int).SetUint64(binary.LittleEndian.Uint64(data[64:72])))
+		}
 	}
 
-	account, err := filterAccountsByToken(accounts, tokenAddress)
+	return total, nil
+}
+
+// a wallet without an account for the token holds none of it, any other failure is an error
+func fetchSPLBalance(address string, tokenAddress string, decimals uint8) (Decimal, error) {
+	c := newSolanaRpcClient()
+
+	var accounts [][]byte
+
+	for _, programId := range []string{common.TokenProgramID.ToBase58(), TOKEN_2022_PROGRAM_ID} {
+		res, err := c.GetTokenAccountsByOwnerWithConfig(
+			context.TODO(),
+			address,
+			rpc.GetTokenAccountsByOwnerConfigFilter{ProgramId: programId},
+			rpc.GetTokenAccountsByOwnerConfig{
+				Encoding:  rpc.AccountEncodingBase64,
+				DataSlice: &rpc.DataSlice{Offset: 0, Length: 72},
+			},
+		)
+		if err != nil {
+			return Decimal{}, err
+		}
+		if res.Error != nil {
+			return Decimal{}, res.Error
+		}
+
+		for _, account := range res.Result.Value {
+			data, err := accountData(account.Account)
+			if err != nil {
+				return Decimal{}, err
+			}
+
+			accounts = append(accounts, data)
+		}
+	}
 
+	// token amounts are u64, keep them in a big.Int rather than risk overflowing an int64
+	total, err := sumTokenAccounts(accounts, tokenAddress)
 	if err != nil {
-		log.Print("Unable to find token balance on account")
+		return Decimal{}, err
 	}
 
-	return float64(account.Amount) / divisorByToken[tokenAddress], nil
+	return Decimal{total, int32(decimals)}, nil
 }
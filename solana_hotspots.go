@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+/*
+ Since the move to Solana hotspots are compressed NFTs held by the
+ owner's wallet, the L1 api's list of an account's hotspots is frozen at
+ the migration. The wallet's assets are listed through the DAS api's
+ getAssetsByOwner, which not every rpc node serves, so it can be pointed
+ at one that does with SOLANA_DAS_URL.
+*/
+
+// each maker mints hotspots into its own collection, every one of them verified by the entity creator
+const HELIUM_ENTITY_CREATOR = "Fv5hf1Fg58htfC7YEXKNEfkpuogUUQDDTLgjGWxxv48H"
+
+// a hotspot's metadata lives here, under its entity key
+const HOTSPOT_METADATA_URL = "https://entities.nft.helium.io/"
+
+// the most getAssetsByOwner returns per page
+const DAS_PAGE_SIZE = 1000
+
+type dasRequest struct {
+	JsonRpc string `json:"jsonrpc"`
+	Id      string `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type dasError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *dasError) Error() string {
+	return fmt.Sprintf("das error %d %s", e.Code, e.Message)
+}
+
+type dasAssetMetadata struct {
+	Name   string `json:"name"`
+	Symbol string `json:"symbol"`
+}
+
+type dasAssetContent struct {
+	JsonUri  string           `json:"json_uri"`
+	Metadata dasAssetMetadata `json:"metadata"`
+}
+
+type dasCreator struct {
+	Address  string `json:"address"`
+	Verified bool   `json:"verified"`
+}
+
+type dasAsset struct {
+	Id       string          `json:"id"`
+	Content  dasAssetContent `json:"content"`
+	Creators []dasCreator    `json:"creators"`
+	Burnt    bool            `json:"burnt"`
+}
+
+type dasAssetList struct {
+	Total int        `json:"total"`
+	Page  int        `json:"page"`
+	Items []dasAsset `json:"items"`
+}
+
+type dasResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *dasError       `json:"error"`
+}
+
+func solanaDasEndpoint() string {
+	endpoint := os.Getenv("SOLANA_DAS_URL")
+
+	if endpoint == "" {
+		return solanaRpcEndpoint()
+	}
+
+	return endpoint
+}
+
+// makes a DAS json-rpc call with named params and decodes its result into value
+func dasCall(method string, params map[string]any, value any) error {
+	endpoint := solanaDasEndpoint()
+
+	body, err := json.Marshal(dasRequest{
+		JsonRpc: "2.0",
+		Id:      "hnt-hmrc",
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	response, err := postJsonUncached(endpoint, body)
+	if err != nil {
+		return err
+	}
+
+	result := dasResponse{}
+
+	err = json.Unmarshal(response, &result)
+	if err != nil {
+		return &DecodeError{endpoint, err}
+	}
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if len(result.Result) == 0 || string(result.Result) == "null" {
+		return &DecodeError{endpoint, fmt.Errorf("%s returned no result", method)}
+	}
+
+	err = json.Unmarshal(result.Result, value)
+	if err != nil {
+		return &DecodeError{endpoint, err}
+	}
+
+	return nil
+}
+
+func fetchAssetsByOwner(owner string, page int) ([]dasAsset, error) {
+	assets := dasAssetList{}
+
+	err := dasCall("getAssetsByOwner", map[string]any{
+		"ownerAddress": owner,
+		"page":         page,
+		"limit":        DAS_PAGE_SIZE,
+	}, &assets)
+
+	return assets.Items, err
+}
+
+func fetchAsset(id string) (dasAsset, error) {
+	asset := dasAsset{}
+
+	err := dasCall("getAsset", map[string]any{"id": id}, &asset)
+
+	return asset, err
+}
+
+/*
+ The hotspot an asset is, if it is one. Anyone can mint an NFT that
+ points at the hotspot metadata, so only assets the entity creator has
+ verified count.
+*/
+func hotspotFromAsset(asset dasAsset) (Hotspot, bool) {
+	if !strings.HasPrefix(asset.Content.JsonUri, HOTSPOT_METADATA_URL) {
+		return Hotspot{}, false
+	}
+
+	verified := false
+
+	for _, creator := range asset.Creators {
+		if creator.Address == HELIUM_ENTITY_CREATOR && creator.Verified {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return Hotspot{}, false
+	}
+
+	entityKey := strings.TrimPrefix(asset.Content.JsonUri, HOTSPOT_METADATA_URL)
+	entityKey = strings.SplitN(entityKey, "?", 2)[0]
+
+	if entityKey == "" {
+		return Hotspot{}, false
+	}
+
+	// the l1 api's names were dashed and lowercase, e.g. angry-purple-tiger
+	name := strings.ToLower(strings.Join(strings.Fields(asset.Content.Metadata.Name), "-"))
+
+	// the entity key is the hotspot's key, the same address it had on the L1
+	return Hotspot{Name: name, Address: entityKey, EntityKey: entityKey, Asset: asset.Id}, true
+}
+
+// the hotspots the wallet holds now, on Solana
+func fetchSolanaHotspots(address string, cache Cache) ([]Hotspot, error) {
+	owner, err := solanaAddressFromHelium(address)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("v1-sol-hotspots-%s", owner)
+
+	cachedData, cacheReadErr := cache.Get(key)
+	if cacheReadErr == nil {
+		var hotspots []Hotspot
+		err := json.Unmarshal([]byte(cachedData), &hotspots)
+		if err == nil {
+			return hotspots, nil
+		}
+	}
+
+	hotspots := []Hotspot{}
+
+	log.Printf("fetching solana hotspots %s", owner)
+	for page := 1; ; page++ {
+		assets, err := fetchAssetsByOwner(owner, page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, asset := range assets {
+			if asset.Burnt {
+				continue
+			}
+
+			if hotspot, ok := hotspotFromAsset(asset); ok {
+				hotspots = append(hotspots, hotspot)
+			}
+		}
+
+		if len(assets) < DAS_PAGE_SIZE {
+			break
+		}
+	}
+	log.Printf("fetched solana hotspots %s", owner)
+
+	// saves looking each one's NFT up again when its rewards are attributed
+	for _, hotspot := range hotspots {
+		cacheAssetHotspot(hotspot.Asset, hotspot.Address, cache)
+	}
+
+	jsonData, err := json.Marshal(hotspots)
+	if err == nil {
+		cacheWriteErr := cache.Set(key, string(jsonData), URL_CACHE_TTL)
+		if cacheWriteErr != nil {
+			log.Printf("Failed to cache %s %s", key, cacheWriteErr)
+		}
+	}
+
+	return hotspots, nil
+}
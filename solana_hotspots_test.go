@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mr-tron/base58"
+)
+
+func TestFetchSolanaHotspots(t *testing.T) {
+	owner := base58.Encode(bytes.Repeat([]byte{7}, 32))
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		var request dasRequest
+		json.NewDecoder(r.Body).Decode(&request)
+
+		params, _ := request.Params.(map[string]any)
+		if request.Method != "getAssetsByOwner" || params["ownerAddress"] != owner {
+			t.Fatalf("Unexpected request %+v", request)
+		}
+
+		w.Write([]byte(`{"jsonrpc": "2.0", "id": "hnt-hmrc", "result": {"total": 4, "page": 1, "items": [
+			{
+				"id": "asset-a",
+				"content": {"json_uri": "` + HOTSPOT_METADATA_URL + `112abc", "metadata": {"name": "Angry Purple Tiger", "symbol": "HOTSPOT"}},
+				"creators": [{"address": "` + HELIUM_ENTITY_CREATOR + `", "verified": true}]
+			},
+			{
+				"id": "asset-spoofed",
+				"content": {"json_uri": "` + HOTSPOT_METADATA_URL + `112def", "metadata": {"name": "Fake Hotspot"}},
+				"creators": [{"address": "` + HELIUM_ENTITY_CREATOR + `", "verified": false}]
+			},
+			{
+				"id": "asset-burnt",
+				"content": {"json_uri": "` + HOTSPOT_METADATA_URL + `112ghi", "metadata": {"name": "Gone Hotspot"}},
+				"creators": [{"address": "` + HELIUM_ENTITY_CREATOR + `", "verified": true}],
+				"burnt": true
+			},
+			{
+				"id": "asset-monkey",
+				"content": {"json_uri": "https://example.com/monkey.json", "metadata": {"name": "Monkey"}},
+				"creators": [{"address": "someone", "verified": true}]
+			}
+		]}}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("SOLANA_DAS_URL", server.URL)
+
+	cache := newMemoryCache(10)
+
+	hotspots, err := fetchSolanaHotspots(owner, cache)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	if len(hotspots) != 1 {
+		t.Fatalf("Expected a single hotspot got %+v", hotspots)
+	}
+
+	expected := Hotspot{Name: "angry-purple-tiger", Address: "112abc", EntityKey: "112abc", Asset: "asset-a"}
+	if hotspots[0] != expected {
+		t.Fatalf("Unexpected hotspot %+v", hotspots[0])
+	}
+
+	// attributing the hotspot's rewards won't need to look its NFT up
+	if hotspot, err := fetchAssetHotspot("asset-a", cache); err != nil || hotspot != "112abc" {
+		t.Fatalf("Unexpected asset hotspot %s %s", hotspot, err)
+	}
+
+	// the second fetch should come from the cache
+	fetchSolanaHotspots(owner, cache)
+
+	if requests != 1 {
+		t.Fatalf("Expected one request got %d", requests)
+	}
+}
+
+func TestFetchAssetsByOwnerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc": "2.0", "id": "hnt-hmrc", "error": {"code": -32601, "message": "Method not found"}}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("SOLANA_DAS_URL", server.URL)
+
+	if _, err := fetchAssetsByOwner("owner1111111111111111111111111111111111111", 1); err == nil {
+		t.Fatalf("Expected the rpc error")
+	}
+}
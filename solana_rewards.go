@@ -0,0 +1,738 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mr-tron/base58"
+	"github.com/portto/solana-go-sdk/client"
+	"github.com/portto/solana-go-sdk/common"
+	"github.com/portto/solana-go-sdk/rpc"
+)
+
+/*
+ Since the move to Solana rewards are no longer paid per hotspot,
+ instead the wallet claims them through the lazy distributor, with
+ the rewards oracle signing off on the amount. The claim pays out
+ of the distributor's escrow into the owner's token account, so we
+ find the claims by walking the wallet's transaction history.
+*/
+const LAZY_DISTRIBUTOR_PROGRAM_ID = "1azyuavdMyvsivtNxPoz6SucD18eDHeXzFCUPq5XU7w"
+const REWARDS_ORACLE_PROGRAM_ID = "rorcfdX4h9m9swCKgcypaHJ8NGYVANBpmV9EHn3cYrF"
+
+// the L1 chain was halted and its rewards moved over on this day
+var SOLANA_MIGRATION_TIME = time.Date(2023, 4, 18, 0, 0, 0, 0, time.UTC)
+
+// the rpc node only hands back 1000 signatures per page
+const SIGNATURES_PAGE_SIZE = 1000
+
+// a recipient or NFT always belongs to the same hotspot, so they are cached without expiry
+const RECIPIENT_CACHE_TTL = 0
+
+// compressed NFTs, hotspots among them, are minted and transferred by bubblegum
+const BUBBLEGUM_PROGRAM_ID = "BGUMAp9Gq7iTEuizy4pqaxsTyUCBK68MDfK752saRPUY"
+
+// anchor names an instruction by the start of sha256("global:<name>")
+var bubblegumTransferDiscriminator = anchorDiscriminator("transfer")
+
+func anchorDiscriminator(name string) []byte {
+	hash := sha256.Sum256([]byte("global:" + name))
+
+	return hash[:8]
+}
+
+type solanaTransactionMessage struct {
+	AccountKeys  []string          `json:"accountKeys"`
+	Instructions []rpc.Instruction `json:"instructions"`
+}
+
+type solanaTransaction struct {
+	Signatures []string                 `json:"signatures"`
+	Message    solanaTransactionMessage `json:"message"`
+}
+
+// the first signature is the one explorers know the transaction by
+func (tx solanaTransaction) hash() string {
+	if len(tx.Signatures) == 0 {
+		return ""
+	}
+
+	return tx.Signatures[0]
+}
+
+func newSolanaRpcClient() rpc.RpcClient {
+	return rpc.New(rpc.WithEndpoint(solanaRpcEndpoint()), rpc.WithHTTPClient(httpClient))
+}
+
+func solanaRpcEndpoint() string {
+	endpoint := os.Getenv("SOLANA_RPC_URL")
+
+	if endpoint == "" {
+		return rpc.MainnetRPCEndpoint
+	}
+
+	return endpoint
+}
+
+/*
+ Helium L1 wallets were carried over to Solana with the same ed25519 key,
+ an L1 address is just base58check(version, key type, key).
+*/
+func solanaAddressFromHelium(address string) (string, error) {
+	decoded, err := base58.Decode(address)
+	if err != nil {
+		return "", err
+	}
+
+	// already a solana address
+	if len(decoded) == 32 {
+		return address, nil
+	}
+
+	if len(decoded) != 38 {
+		return "", fmt.Errorf("%s is not a helium or solana address", address)
+	}
+
+	payload := decoded[:34]
+	checksum := decoded[34:]
+
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	if string(second[:4]) != string(checksum) {
+		return "", fmt.Errorf("%s has an invalid checksum", address)
+	}
+
+	// 0x01 is the ed25519 key type, anything else can't exist on solana
+	if payload[1] != 0x01 {
+		return "", fmt.Errorf("%s is not an ed25519 address", address)
+	}
+
+	return base58.Encode(payload[2:]), nil
+}
+
+func transactionAccountKeys(tx solanaTransaction, meta *rpc.TransactionMeta) []string {
+	keys := append([]string{}, tx.Message.AccountKeys...)
+
+	// v0 transactions pull the remaining accounts in from lookup tables
+	keys = append(keys, meta.LoadedAddresses.Writable...)
+	keys = append(keys, meta.LoadedAddresses.Readonly...)
+
+	return keys
+}
+
+// an instruction another one made, the node says how deep in the calls it ran when it's recent enough
+type innerInstruction struct {
+	rpc.Instruction
+	// 2 for the calls a top level instruction made, 0 when it isn't known
+	StackHeight int `json:"stackHeight"`
+}
+
+// the instructions each top level instruction made, keyed by its index
+func innerInstructionsByIndex(meta *rpc.TransactionMeta) map[uint64][]innerInstruction {
+	byIndex := make(map[uint64][]innerInstruction)
+
+	for _, inner := range meta.InnerInstructions {
+		raw, err := json.Marshal(inner.Instructions)
+		if err != nil {
+			continue
+		}
+
+		var instructions []innerInstruction
+		if json.Unmarshal(raw, &instructions) != nil {
+			continue
+		}
+
+		byIndex[inner.Index] = append(byIndex[inner.Index], instructions...)
+	}
+
+	return byIndex
+}
+
+func invokesProgram(tx solanaTransaction, meta *rpc.TransactionMeta, programId string) bool {
+	keys := transactionAccountKeys(tx, meta)
+
+	isProgram := func(index int) bool {
+		return index >= 0 && index < len(keys) && keys[index] == programId
+	}
+
+	for _, instruction := range tx.Message.Instructions {
+		if isProgram(instruction.ProgramIDIndex) {
+			return true
+		}
+	}
+
+	for _, instructions := range innerInstructionsByIndex(meta) {
+		for _, instruction := range instructions {
+			if isProgram(instruction.ProgramIDIndex) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// the account a token program transfer paid into and how much, if the instruction is one
+func decodeTokenTransfer(keys []string, instruction rpc.Instruction) (int, int64, bool) {
+	if instruction.ProgramIDIndex < 0 || instruction.ProgramIDIndex >= len(keys) {
+		return 0, 0, false
+	}
+
+	// token 2022 kept the token program's transfer instructions as they were
+	program := keys[instruction.ProgramIDIndex]
+	if program != common.TokenProgramID.ToBase58() && program != TOKEN_2022_PROGRAM_ID {
+		return 0, 0, false
+	}
+
+	data, err := base58.Decode(instruction.Data)
+	if err != nil || len(data) < 9 {
+		return 0, 0, false
+	}
+
+	amount := int64(binary.LittleEndian.Uint64(data[1:9]))
+
+	switch {
+	// transfer, the accounts are source, destination, authority
+	case data[0] == 3 && len(instruction.Accounts) >= 2:
+		return instruction.Accounts[1], amount, true
+	// transfer checked, the accounts are source, mint, destination, authority
+	case data[0] == 12 && len(instruction.Accounts) >= 3:
+		return instruction.Accounts[2], amount, true
+	}
+
+	return 0, 0, false
+}
+
+// what a claim paid into the wallet for one hotspot, which is known by its lazy distributor recipient
+type recipientClaim struct {
+	recipient string
+	amount    int64
+}
+
+/*
+ Each hotspot's rewards are paid out by its own distribute instruction,
+ whose accounts start payer, lazy distributor, recipient, rewards mint.
+ The recipient is the account the lazy distributor keeps for the
+ hotspot's NFT, and the token transfers the instruction made into the
+ wallet's accounts are what it paid. Other programs, like helpers that
+ claim and compound, call distribute themselves, so it's looked for
+ among the inner instructions too.
+*/
+func recipientClaims(tx solanaTransaction, meta *rpc.TransactionMeta, mint string, ownerAccounts map[uint64]int64) []recipientClaim {
+	keys := transactionAccountKeys(tx, meta)
+	inner := innerInstructionsByIndex(meta)
+
+	isDistribute := func(instruction rpc.Instruction) bool {
+		return instruction.ProgramIDIndex >= 0 && instruction.ProgramIDIndex < len(keys) &&
+			keys[instruction.ProgramIDIndex] == LAZY_DISTRIBUTOR_PROGRAM_ID && len(instruction.Accounts) >= 4
+	}
+
+	var claims []recipientClaim
+
+	claim := func(distribute rpc.Instruction, transfers []innerInstruction) {
+		recipientIndex, mintIndex := distribute.Accounts[2], distribute.Accounts[3]
+		if mintIndex >= len(keys) || recipientIndex >= len(keys) || keys[mintIndex] != mint {
+			return
+		}
+
+		var amount int64
+
+		for _, transfer := range transfers {
+			destination, transferred, ok := decodeTokenTransfer(keys, transfer.Instruction)
+			if !ok {
+				continue
+			}
+
+			if _, owned := ownerAccounts[uint64(destination)]; owned {
+				amount += transferred
+			}
+		}
+
+		if amount > 0 {
+			claims = append(claims, recipientClaim{keys[recipientIndex], amount})
+		}
+	}
+
+	for i, instruction := range tx.Message.Instructions {
+		calls := inner[uint64(i)]
+
+		if isDistribute(instruction) {
+			claim(instruction, calls)
+			continue
+		}
+
+		for j, call := range calls {
+			if !isDistribute(call.Instruction) {
+				continue
+			}
+
+			// the calls that follow until the stack unwinds are the ones distribute made
+			end := j + 1
+			for ; end < len(calls); end++ {
+				if call.StackHeight > 0 && calls[end].StackHeight > 0 {
+					if calls[end].StackHeight <= call.StackHeight {
+						break
+					}
+				} else if isDistribute(calls[end].Instruction) {
+					// without stack heights the next distribute is as far as we can tell
+					break
+				}
+			}
+
+			claim(call.Instruction, calls[j+1:end])
+		}
+	}
+
+	return claims
+}
+
+func tokenAmountByAccount(balances []rpc.TransactionMetaTokenBalance, owner string, mint string) map[uint64]int64 {
+	amounts := make(map[uint64]int64)
+
+	for _, balance := range balances {
+		if balance.Owner != owner || balance.Mint != mint {
+			continue
+		}
+
+		amount, err := strconv.ParseInt(balance.UITokenAmount.Amount, 10, 64)
+		if err != nil {
+			log.Printf("Unable to parse token amount %s", balance.UITokenAmount.Amount)
+			continue
+		}
+
+		amounts[balance.AccountIndex] = amount
+	}
+
+	return amounts
+}
+
+// the address of the hotspot an account belongs to, empty when it isn't a hotspot's, see fetchRecipientHotspot
+type hotspotResolver func(account string) (string, error)
+
+// false for transactions that failed, which still show up in the history
+func parseTransaction(result *rpc.GetTransaction) (solanaTransaction, bool, error) {
+	var tx solanaTransaction
+
+	if result == nil || result.Meta == nil || result.BlockTime == nil {
+		return tx, false, fmt.Errorf("transaction is missing its meta data")
+	}
+
+	if result.Meta.Err != nil {
+		return tx, false, nil
+	}
+
+	raw, err := json.Marshal(result.Transaction)
+	if err != nil {
+		return tx, false, err
+	}
+
+	err = json.Unmarshal(raw, &tx)
+	if err != nil {
+		return tx, false, err
+	}
+
+	return tx, true, nil
+}
+
+/*
+ Turns a claim transaction into the rewards it paid the wallet, measured
+ in base units the same way the L1 api reported bones. Each reward is
+ attributed to the hotspot it was claimed for, anything the wallet
+ received that we can't tie to a hotspot is left unattributed.
+*/
+func decodeClaimTransaction(result *rpc.GetTransaction, owner string, tokenByMint map[string]string, hotspotOf hotspotResolver) ([]Reward, error) {
+	tx, ok, err := parseTransaction(result)
+	if !ok || err != nil {
+		return nil, err
+	}
+
+	if !invokesProgram(tx, result.Meta, LAZY_DISTRIBUTOR_PROGRAM_ID) &&
+		!invokesProgram(tx, result.Meta, REWARDS_ORACLE_PROGRAM_ID) {
+		return nil, nil
+	}
+
+	var rewards []Reward
+	timestamp := RewardTime(time.Unix(*result.BlockTime, 0).UTC())
+
+	// one transaction can claim several tokens
+	for _, mint := range sortedKeys(tokenByMint) {
+		pre := tokenAmountByAccount(result.Meta.PreTokenBalances, owner, mint)
+		post := tokenAmountByAccount(result.Meta.PostTokenBalances, owner, mint)
+
+		var received int64
+
+		for index, postAmount := range post {
+			// the token account may have been opened by the claim itself
+			delta := postAmount - pre[index]
+
+			if delta > 0 {
+				received += delta
+			}
+		}
+
+		if received <= 0 {
+			continue
+		}
+
+		var attributed []Reward
+		var claimed int64
+
+		for _, claim := range recipientClaims(tx, result.Meta, mint, post) {
+			// without the hotspot the claim is still income, so it's left unattributed
+			hotspot, err := hotspotOf(claim.recipient)
+			if err != nil {
+				log.Printf("Unable to find the hotspot for recipient %s %s", claim.recipient, err)
+				continue
+			}
+
+			claimed += claim.amount
+			attributed = append(attributed, Reward{
+				Account:   owner,
+				Gateway:   hotspot,
+				Amount:    claim.amount,
+				Timestamp: timestamp,
+				Token:     tokenByMint[mint],
+				Hash:      tx.hash(),
+			})
+		}
+
+		// the wallet can't have been paid more than it received, if it was the transfers weren't what we took them for
+		if claimed > received {
+			attributed, claimed = nil, 0
+		}
+
+		rewards = append(rewards, attributed...)
+
+		if received > claimed {
+			rewards = append(rewards, Reward{
+				Account:   owner,
+				Amount:    received - claimed,
+				Timestamp: timestamp,
+				Token:     tokenByMint[mint],
+				Hash:      tx.hash(),
+			})
+		}
+	}
+
+	return rewards, nil
+}
+
+// the mints of the reward tokens, mapped back to their symbol
+func rewardTokenMints() map[string]string {
+	tokenByMint := make(map[string]string)
+
+	for _, token := range rewardTokens {
+		tokenByMint[addressByToken[token]] = token
+	}
+
+	return tokenByMint
+}
+
+func sortedKeys(values map[string]string) []string {
+	var keys []string
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+/*
+ A recipient is created for one hotspot NFT and never changes, the asset
+ sits after the 8 byte discriminator and the lazy distributor's key. The
+ hotspot is the asset's entity key, which is its address from the L1.
+*/
+func fetchRecipientHotspot(recipient string, cache Cache) (string, error) {
+	key := fmt.Sprintf("v1-sol-recipient-%s", recipient)
+
+	cachedData, cacheReadErr := cache.Get(key)
+	if cacheReadErr == nil {
+		return cachedData, nil
+	}
+
+	c := &client.Client{RpcClient: newSolanaRpcClient()}
+
+	account, err := c.GetAccountInfo(context.TODO(), recipient)
+	if err != nil {
+		return "", err
+	}
+
+	if account.Owner.ToBase58() != LAZY_DISTRIBUTOR_PROGRAM_ID || len(account.Data) < 72 {
+		return "", fmt.Errorf("%s is not a lazy distributor recipient", recipient)
+	}
+
+	// rewards for anything that isn't a hotspot stay unattributed
+	hotspot, err := fetchAssetHotspot(base58.Encode(account.Data[40:72]), cache)
+	if err != nil {
+		return "", err
+	}
+
+	cacheWriteErr := cache.Set(key, hotspot, RECIPIENT_CACHE_TTL)
+	if cacheWriteErr != nil {
+		log.Printf("Failed to cache %s %s", key, cacheWriteErr)
+	}
+
+	return hotspot, nil
+}
+
+// the address of the hotspot an NFT is, empty when it isn't one
+func fetchAssetHotspot(asset string, cache Cache) (string, error) {
+	key := fmt.Sprintf("v1-sol-asset-%s", asset)
+
+	cachedData, cacheReadErr := cache.Get(key)
+	if cacheReadErr == nil {
+		return cachedData, nil
+	}
+
+	details, err := fetchAsset(asset)
+	if err != nil {
+		return "", err
+	}
+
+	hotspot, _ := hotspotFromAsset(details)
+
+	cacheAssetHotspot(asset, hotspot.Address, cache)
+
+	return hotspot.Address, nil
+}
+
+// an NFT is always the same hotspot, so it's kept as long as a recipient
+func cacheAssetHotspot(asset string, hotspot string, cache Cache) {
+	key := fmt.Sprintf("v1-sol-asset-%s", asset)
+
+	cacheWriteErr := cache.Set(key, hotspot, RECIPIENT_CACHE_TTL)
+	if cacheWriteErr != nil {
+		log.Printf("Failed to cache %s %s", key, cacheWriteErr)
+	}
+}
+
+/*
+ Hotspots are compressed NFTs, so changing hands is a bubblegum transfer
+ whose accounts are tree authority, leaf owner, leaf delegate, new leaf
+ owner, merkle tree. The NFT's asset id is derived from the tree and the
+ leaf's nonce, which follows the root and the two hashes in the data.
+*/
+func decodeHotspotTransfers(result *rpc.GetTransaction, owner string, hotspotOf hotspotResolver) ([]HotspotTransfer, error) {
+	tx, ok, err := parseTransaction(result)
+	if !ok || err != nil {
+		return nil, err
+	}
+
+	keys := transactionAccountKeys(tx, result.Meta)
+	instructions := append([]rpc.Instruction{}, tx.Message.Instructions...)
+
+	// marketplaces make the transfer themselves
+	for _, inner := range innerInstructionsByIndex(result.Meta) {
+		for _, instruction := range inner {
+			instructions = append(instructions, instruction.Instruction)
+		}
+	}
+
+	key := func(index int) string {
+		if index < 0 || index >= len(keys) {
+			return ""
+		}
+
+		return keys[index]
+	}
+
+	hash := tx.hash()
+
+	var transfers []HotspotTransfer
+
+	for _, instruction := range instructions {
+		if key(instruction.ProgramIDIndex) != BUBBLEGUM_PROGRAM_ID || len(instruction.Accounts) < 5 {
+			continue
+		}
+
+		data, err := base58.Decode(instruction.Data)
+		if err != nil || len(data) < 116 || !bytes.Equal(data[:8], bubblegumTransferDiscriminator) {
+			continue
+		}
+
+		from, to := key(instruction.Accounts[1]), key(instruction.Accounts[3])
+		if from != owner && to != owner {
+			continue
+		}
+
+		tree, err := base58.Decode(key(instruction.Accounts[4]))
+		if err != nil || len(tree) != common.PublicKeyLength {
+			continue
+		}
+
+		asset, _, err := common.FindProgramAddress(
+			[][]byte{[]byte("asset"), tree, data[104:112]},
+			common.PublicKeyFromString(BUBBLEGUM_PROGRAM_ID))
+		if err != nil {
+			return nil, err
+		}
+
+		// a transfer we can't place leaves the hotspot counted as owned throughout
+		hotspot, err := hotspotOf(asset.ToBase58())
+		if err != nil {
+			log.Printf("Unable to find the hotspot for asset %s %s", asset.ToBase58(), err)
+			continue
+		}
+
+		if hotspot == "" {
+			continue
+		}
+
+		transfers = append(transfers, HotspotTransfer{
+			Hotspot:   hotspot,
+			From:      from,
+			To:        to,
+			Timestamp: RewardTime(time.Unix(*result.BlockTime, 0).UTC()),
+			Hash:      hash,
+			Block:     int64(result.Slot),
+		})
+	}
+
+	return transfers, nil
+}
+
+// what one of the wallet's transactions did that matters to the report
+type solanaActivity struct {
+	Rewards   []Reward          `json:"rewards"`
+	Transfers []HotspotTransfer `json:"transfers"`
+}
+
+func fetchSolanaActivity(c *rpc.RpcClient, signature string, owner string, cache Cache) (solanaActivity, error) {
+	key := fmt.Sprintf("v3-sol-activity-%s", signature)
+
+	// a finalized transaction never changes, so what we decoded can be kept
+	cachedData, cacheReadErr := cache.Get(key)
+	if cacheReadErr == nil {
+		var activity solanaActivity
+		err := json.Unmarshal([]byte(cachedData), &activity)
+		if err == nil {
+			return activity, nil
+		}
+	}
+
+	var maxVersion uint8 = 0
+	res, err := c.GetTransactionWithConfig(context.TODO(), signature, rpc.GetTransactionConfig{
+		Encoding:                       rpc.TransactionEncodingJson,
+		Commitment:                     rpc.CommitmentFinalized,
+		MaxSupportedTransactionVersion: &maxVersion,
+	})
+	if err != nil {
+		return solanaActivity{}, err
+	}
+	if res.Error != nil {
+		return solanaActivity{}, res.Error
+	}
+
+	// attribution needs a DAS node, when it fails the rest is kept but not cached so it's tried again later
+	resolved := true
+
+	rewards, err := decodeClaimTransaction(res.Result, owner, rewardTokenMints(), func(recipient string) (string, error) {
+		hotspot, err := fetchRecipientHotspot(recipient, cache)
+		resolved = resolved && err == nil
+		return hotspot, err
+	})
+	if err != nil {
+		return solanaActivity{}, err
+	}
+
+	transfers, err := decodeHotspotTransfers(res.Result, owner, func(asset string) (string, error) {
+		hotspot, err := fetchAssetHotspot(asset, cache)
+		resolved = resolved && err == nil
+		return hotspot, err
+	})
+	if err != nil {
+		return solanaActivity{}, err
+	}
+
+	activity := solanaActivity{rewards, transfers}
+
+	jsonData, err := json.Marshal(activity)
+	if err == nil && resolved {
+		cacheWriteErr := cache.Set(key, string(jsonData), RESULT_CACHE_TTL)
+		if cacheWriteErr != nil {
+			log.Printf("Failed to cache %s %s", key, cacheWriteErr)
+		}
+	}
+
+	return activity, nil
+}
+
+// the wallet's claims between startTime and endTime, and the hotspots it sent or received in that time
+func fetchSolanaRewards(address string, cache Cache, startTime time.Time, endTime time.Time) ([]Reward, []HotspotTransfer, error) {
+	owner, err := solanaAddressFromHelium(address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := newSolanaRpcClient()
+
+	var allRewards []Reward
+	var allTransfers []HotspotTransfer
+	before := ""
+
+	log.Printf("fetching solana rewards %s", owner)
+	for {
+		res, err := c.GetSignaturesForAddressWithConfig(context.TODO(), owner, rpc.GetSignaturesForAddressConfig{
+			Limit:      SIGNATURES_PAGE_SIZE,
+			Before:     before,
+			Commitment: rpc.CommitmentFinalized,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if res.Error != nil {
+			return nil, nil, res.Error
+		}
+
+		reachedStart := false
+
+		for _, signature := range res.Result {
+			if signature.BlockTime == nil || signature.Err != nil {
+				continue
+			}
+
+			blockTime := time.Unix(*signature.BlockTime, 0)
+
+			// newest first, so once we pass the start there is nothing left
+			if blockTime.Before(startTime) {
+				reachedStart = true
+				break
+			}
+
+			// only a hotspot's owner can claim, so later transfers can't change what the wallet earned
+			if !blockTime.Before(endTime) {
+				continue
+			}
+
+			activity, err := fetchSolanaActivity(&c, signature.Signature, owner, cache)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			allRewards = append(allRewards, activity.Rewards...)
+			allTransfers = append(allTransfers, activity.Transfers...)
+		}
+
+		if reachedStart || len(res.Result) < SIGNATURES_PAGE_SIZE {
+			break
+		}
+
+		before = res.Result[len(res.Result)-1].Signature
+	}
+	log.Printf("fetched solana rewards %s", owner)
+
+	return allRewards, allTransfers, nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mr-tron/base58"
+	"github.com/portto/solana-go-sdk/common"
+	"github.com/portto/solana-go-sdk/rpc"
+)
+
+func TestSolanaAddressFromHelium(t *testing.T) {
+	address, err := solanaAddressFromHelium("13bEUjESeAQcryWWfuc7jvnRJEDg7aTBANriCvrSmQ6N4zcgB8t")
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	// a solana address should come straight back
+	same, err := solanaAddressFromHelium(address)
+	if err != nil || same != address {
+		t.Fatalf("Expected %s got %s %v", address, same, err)
+	}
+
+	_, err = solanaAddressFromHelium("13bEUjESeAQcryWWfuc7jvnRJEDg7aTBANriCvrSmQ6N4zcgB8u")
+	if err == nil {
+		t.Fatalf("Expected a checksum failure")
+	}
+}
+
+func TestDecodeClaimTransaction(t *testing.T) {
+	owner := "owner1111111111111111111111111111111111111"
+	mint := addressByToken["hnt"]
+	iotMint := addressByToken["iot"]
+	blockTime := int64(1690000000)
+
+	var tx any
+	json.Unmarshal([]byte(`{
+		"signatures": ["sig"],
+		"message": {
+			"accountKeys": ["payer", "`+LAZY_DISTRIBUTOR_PROGRAM_ID+`"],
+			"instructions": [{"programIdIndex": 1, "accounts": [0], "data": ""}]
+		}
+	}`), &tx)
+
+	result := &rpc.GetTransaction{
+		BlockTime:   &blockTime,
+		Transaction: tx,
+		Meta: &rpc.TransactionMeta{
+			PreTokenBalances: []rpc.TransactionMetaTokenBalance{
+				{AccountIndex: 2, Mint: mint, Owner: owner, UITokenAmount: rpc.TokenAccountBalance{Amount: "100"}},
+			},
+			PostTokenBalances: []rpc.TransactionMetaTokenBalance{
+				{AccountIndex: 2, Mint: mint, Owner: owner, UITokenAmount: rpc.TokenAccountBalance{Amount: "350"}},
+				{AccountIndex: 3, Mint: mint, Owner: "someone else", UITokenAmount: rpc.TokenAccountBalance{Amount: "999"}},
+				{AccountIndex: 4, Mint: iotMint, Owner: owner, UITokenAmount: rpc.TokenAccountBalance{Amount: "7000000"}},
+			},
+		},
+	}
+
+	rewards, err := decodeClaimTransaction(result, owner, rewardTokenMints(), func(recipient string) (string, error) {
+		t.Fatalf("Nothing to attribute, but looked up %s", recipient)
+		return "", nil
+	})
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	byToken := make(map[string]int64)
+	for _, reward := range rewards {
+		byToken[reward.token()] = reward.Amount
+	}
+
+	// a claim can pay out more than one token, each is its own reward
+	if len(rewards) != 2 || byToken["hnt"] != 250 || byToken["iot"] != 7000000 {
+		t.Fatalf("Expected rewards of 250 HNT and 7000000 IOT got %v", rewards)
+	}
+
+	if !time.Time(rewards[0].Timestamp).Equal(time.Unix(blockTime, 0)) {
+		t.Fatalf("Unexpected timestamp %v", time.Time(rewards[0].Timestamp))
+	}
+}
+
+func tokenTransferData(instruction byte, amount uint64) string {
+	data := []byte{instruction}
+	data = binary.LittleEndian.AppendUint64(data, amount)
+
+	return base58.Encode(data)
+}
+
+func TestDecodeAttributedClaimTransaction(t *testing.T) {
+	owner := "owner1111111111111111111111111111111111111"
+	mint := addressByToken["hnt"]
+	blockTime := int64(1690000000)
+
+	var tx any
+	json.Unmarshal([]byte(`{
+		"signatures": ["sig"],
+		"message": {
+			"accountKeys": ["payer", "`+LAZY_DISTRIBUTOR_PROGRAM_ID+`", "distributor", "recipient-a", "recipient-b", "`+mint+`", "escrow", "owner-account", "`+common.TokenProgramID.ToBase58()+`"],
+			"instructions": [
+				{"programIdIndex": 1, "accounts": [0, 2, 3, 5, 6, 7], "data": ""},
+				{"programIdIndex": 1, "accounts": [0, 2, 4, 5, 6, 7], "data": ""}
+			]
+		}
+	}`), &tx)
+
+	result := &rpc.GetTransaction{
+		BlockTime:   &blockTime,
+		Transaction: tx,
+		Meta: &rpc.TransactionMeta{
+			InnerInstructions: []rpc.TransactionMetaInnerInstruction{
+				{Index: 0, Instructions: []any{rpc.Instruction{ProgramIDIndex: 8, Accounts: []int{6, 7, 0}, Data: tokenTransferData(3, 100)}}},
+				{Index: 1, Instructions: []any{rpc.Instruction{ProgramIDIndex: 8, Accounts: []int{6, 5, 7, 0}, Data: tokenTransferData(12, 150)}}},
+			},
+			PreTokenBalances: []rpc.TransactionMetaTokenBalance{
+				{AccountIndex: 7, Mint: mint, Owner: owner, UITokenAmount: rpc.TokenAccountBalance{Amount: "0"}},
+			},
+			PostTokenBalances: []rpc.TransactionMetaTokenBalance{
+				{AccountIndex: 7, Mint: mint, Owner: owner, UITokenAmount: rpc.TokenAccountBalance{Amount: "300"}},
+			},
+		},
+	}
+
+	hotspots := map[string]string{"recipient-a": "hotspot-a", "recipient-b": "hotspot-b"}
+
+	rewards, err := decodeClaimTransaction(result, owner, rewardTokenMints(), func(recipient string) (string, error) {
+		return hotspots[recipient], nil
+	})
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	// each hotspot gets what its distribute instruction paid, the rest is unattributed
+	if len(rewards) != 3 ||
+		rewards[0].Gateway != "hotspot-a" || rewards[0].Amount != 100 ||
+		rewards[1].Gateway != "hotspot-b" || rewards[1].Amount != 150 ||
+		rewards[2].Gateway != "" || rewards[2].Amount != 50 {
+		t.Fatalf("Unexpected rewards %+v", rewards)
+	}
+
+	// without a DAS node the hotspot can't be found, the claim is still income
+	rewards, err = decodeClaimTransaction(result, owner, rewardTokenMints(), func(recipient string) (string, error) {
+		if recipient == "recipient-b" {
+			return "", errors.New("Method not found")
+		}
+
+		return hotspots[recipient], nil
+	})
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	if len(rewards) != 2 ||
+		rewards[0].Gateway != "hotspot-a" || rewards[0].Amount != 100 ||
+		rewards[1].Gateway != "" || rewards[1].Amount != 200 {
+		t.Fatalf("Unexpected rewards %+v", rewards)
+	}
+}
+
+func TestDecodeInnerDistributeClaimTransaction(t *testing.T) {
+	owner := "owner1111111111111111111111111111111111111"
+	mint := addressByToken["hnt"]
+	blockTime := int64(1690000000)
+
+	var tx any
+	json.Unmarshal([]byte(`{
+		"signatures": ["sig"],
+		"message": {
+			"accountKeys": ["payer", "`+LAZY_DISTRIBUTOR_PROGRAM_ID+`", "distributor", "recipient-a", "recipient-b", "`+mint+`", "escrow", "owner-account", "`+common.TokenProgramID.ToBase58()+`", "helper", "`+TOKEN_2022_PROGRAM_ID+`"],
+			"instructions": [
+				{"programIdIndex": 9, "accounts": [0, 2, 3, 4, 5, 6, 7], "data": ""}
+			]
+		}
+	}`), &tx)
+
+	// a helper that claims for two hotspots through distribute, then pays the wallet something of its own
+	result := &rpc.GetTransaction{
+		BlockTime:   &blockTime,
+		Transaction: tx,
+		Meta: &rpc.TransactionMeta{
+			InnerInstructions: []rpc.TransactionMetaInnerInstruction{
+				{Index: 0, Instructions: []any{
+					innerInstruction{rpc.Instruction{ProgramIDIndex: 1, Accounts: []int{0, 2, 3, 5, 6, 7}, Data: ""}, 2},
+					innerInstruction{rpc.Instruction{ProgramIDIndex: 8, Accounts: []int{6, 7, 0}, Data: tokenTransferData(3, 100)}, 3},
+					innerInstruction{rpc.Instruction{ProgramIDIndex: 1, Accounts: []int{0, 2, 4, 5, 6, 7}, Data: ""}, 2},
+					innerInstruction{rpc.Instruction{ProgramIDIndex: 10, Accounts: []int{6, 5, 7, 0}, Data: tokenTransferData(12, 150)}, 3},
+					innerInstruction{rpc.Instruction{ProgramIDIndex: 8, Accounts: []int{6, 7, 0}, Data: tokenTransferData(3, 50)}, 2},
+				}},
+			},
+			PreTokenBalances: []rpc.TransactionMetaTokenBalance{
+				{AccountIndex: 7, Mint: mint, Owner: owner, UITokenAmount: rpc.TokenAccountBalance{Amount: "0"}},
+			},
+			PostTokenBalances: []rpc.TransactionMetaTokenBalance{
+				{AccountIndex: 7, Mint: mint, Owner: owner, UITokenAmount: rpc.TokenAccountBalance{Amount: "300"}},
+			},
+		},
+	}
+
+	hotspots := map[string]string{"recipient-a": "hotspot-a", "recipient-b": "hotspot-b"}
+
+	rewards, err := decodeClaimTransaction(result, owner, rewardTokenMints(), func(recipient string) (string, error) {
+		return hotspots[recipient], nil
+	})
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	// the helper's own transfer ran after distribute returned, so it isn't a hotspot's
+	if len(rewards) != 3 ||
+		rewards[0].Gateway != "hotspot-a" || rewards[0].Amount != 100 ||
+		rewards[1].Gateway != "hotspot-b" || rewards[1].Amount != 150 ||
+		rewards[2].Gateway != "" || rewards[2].Amount != 50 || rewards[2].Hash != "sig" {
+		t.Fatalf("Unexpected rewards %+v", rewards)
+	}
+}
+
+func bubblegumTransferData(nonce uint64) string {
+	data := append([]byte{}, bubblegumTransferDiscriminator...)
+	data = append(data, make([]byte, 96)...)
+	data = binary.LittleEndian.AppendUint64(data, nonce)
+	data = binary.LittleEndian.AppendUint32(data, uint32(nonce))
+
+	return base58.Encode(data)
+}
+
+func TestDecodeHotspotTransfers(t *testing.T) {
+	owner := "owner1111111111111111111111111111111111111"
+	tree := common.PublicKeyFromBytes(bytes.Repeat([]byte{9}, 32))
+	blockTime := int64(1690000000)
+
+	assetAt := func(nonce uint64) string {
+		asset, _, _ := common.FindProgramAddress(
+			[][]byte{[]byte("asset"), tree.Bytes(), binary.LittleEndian.AppendUint64(nil, nonce)},
+			common.PublicKeyFromString(BUBBLEGUM_PROGRAM_ID))
+
+		return asset.ToBase58()
+	}
+
+	var tx any
+	json.Unmarshal([]byte(`{
+		"signatures": ["sig"],
+		"message": {
+			"accountKeys": ["payer", "`+BUBBLEGUM_PROGRAM_ID+`", "authority", "`+owner+`", "buyer", "`+tree.ToBase58()+`", "marketplace", "seller"],
+			"instructions": [
+				{"programIdIndex": 1, "accounts": [2, 3, 3, 4, 5], "data": "`+bubblegumTransferData(1)+`"},
+				{"programIdIndex": 1, "accounts": [2, 3, 3, 4, 5], "data": "`+bubblegumTransferData(2)+`"},
+				{"programIdIndex": 6, "accounts": [0], "data": ""}
+			]
+		}
+	}`), &tx)
+
+	result := &rpc.GetTransaction{
+		BlockTime:   &blockTime,
+		Transaction: tx,
+		Meta: &rpc.TransactionMeta{
+			InnerInstructions: []rpc.TransactionMetaInnerInstruction{
+				// bought through a marketplace
+				{Index: 2, Instructions: []any{rpc.Instruction{ProgramIDIndex: 1, Accounts: []int{2, 7, 7, 3, 5}, Data: bubblegumTransferData(3)}}},
+			},
+		},
+	}
+
+	// the second NFT isn't a hotspot
+	hotspots := map[string]string{assetAt(1): "hotspot-a", assetAt(3): "hotspot-c"}
+
+	transfers, err := decodeHotspotTransfers(result, owner, func(asset string) (string, error) {
+		return hotspots[asset], nil
+	})
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	expected := []HotspotTransfer{
+		{Hotspot: "hotspot-a", From: owner, To: "buyer", Timestamp: RewardTime(time.Unix(blockTime, 0).UTC()), Hash: "sig"},
+		{Hotspot: "hotspot-c", From: "seller", To: owner, Timestamp: RewardTime(time.Unix(blockTime, 0).UTC()), Hash: "sig"},
+	}
+
+	if len(transfers) != len(expected) || transfers[0] != expected[0] || transfers[1] != expected[1] {
+		t.Fatalf("Unexpected transfers %+v", transfers)
+	}
+}
+
+func TestFetchSolanaRewardsStopsAtEnd(t *testing.T) {
+	owner := base58.Encode(bytes.Repeat([]byte{7}, 32))
+	start := time.Date(2023, 4, 6, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 4, 6, 0, 0, 0, 0, time.UTC)
+	var fetched []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+
+		switch request.Method {
+		case "getSignaturesForAddress":
+			w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": [
+				{"signature": "sig-after", "slot": 3, "blockTime": ` + fmt.Sprint(end.Add(time.Hour).Unix()) + `},
+				{"signature": "sig-during", "slot": 2, "blockTime": ` + fmt.Sprint(end.Add(-time.Hour).Unix()) + `},
+				{"signature": "sig-before", "slot": 1, "blockTime": ` + fmt.Sprint(start.Add(-time.Hour).Unix()) + `}
+			]}`))
+		case "getTransaction":
+			signature := fmt.Sprint(request.Params[0])
+			fetched = append(fetched, signature)
+
+			w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": {"slot": 2, "blockTime": ` + fmt.Sprint(end.Add(-time.Hour).Unix()) + `,
+				"meta": {"err": null, "preTokenBalances": [], "postTokenBalances": [], "innerInstructions": [], "loadedAddresses": {"writable": [], "readonly": []}},
+				"transaction": {"signatures": ["` + signature + `"], "message": {"accountKeys": [], "instructions": []}}}}`))
+		default:
+			t.Fatalf("Unexpected request %+v", request)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("SOLANA_RPC_URL", server.URL)
+
+	_, _, err := fetchSolanaRewards(owner, newMemoryCache(10), start, end)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	// nothing after the end of the report is worth decoding
+	if len(fetched) != 1 || fetched[0] != "sig-during" {
+		t.Fatalf("Unexpected transactions fetched %v", fetched)
+	}
+}
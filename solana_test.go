@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mr-tron/base58"
+	"github.com/portto/solana-go-sdk/common"
+)
+
+func TestRegisterTokens(t *testing.T) {
+	defer func(registry map[string]string) { addressByToken = registry }(maps.Clone(addressByToken))
+
+	jup := "JUPyiwrYJFskUPiHa7hkeR8VUtAeFoSYbKedZNsDvCN"
+
+	if err := registerTokens(" JUP = " + jup + ", "); err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	if addressByToken["jup"] != jup || addressByToken["hnt"] == "" {
+		t.Fatalf("Unexpected registry %v", addressByToken)
+	}
+
+	for _, config := range []string{"jup", "jup=not-a-mint"} {
+		if err := registerTokens(config); err == nil {
+			t.Fatalf("Expected %s to be rejected", config)
+		}
+	}
+}
+
+func TestResolveTokenMint(t *testing.T) {
+	if mint, err := resolveTokenMint("IOT"); err != nil || mint != addressByToken["iot"] {
+		t.Fatalf("Unexpected mint %s %s", mint, err)
+	}
+
+	// any mint is accepted as it is
+	mint := base58.Encode(bytes.Repeat([]byte{3}, 32))
+	if resolved, err := resolveTokenMint(mint); err != nil || resolved != mint {
+		t.Fatalf("Unexpected mint %s %s", resolved, err)
+	}
+
+	if _, err := resolveTokenMint("doge"); err == nil {
+		t.Fatalf("Expected an unknown token")
+	}
+}
+
+func tokenAccountData(mint string, amount uint64) string {
+	decoded, _ := base58.Decode(mint)
+
+	data := append(decoded, bytes.Repeat([]byte{1}, 32)...)
+	data = binary.LittleEndian.AppendUint64(data, amount)
+
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func TestFetchSPLBalance(t *testing.T) {
+	owner := base58.Encode(bytes.Repeat([]byte{7}, 32))
+	mint := base58.Encode(bytes.Repeat([]byte{3}, 32))
+	other := base58.Encode(bytes.Repeat([]byte{4}, 32))
+
+	// the wallet holds the mint under Token-2022, and something else under the original program
+	accountsByProgram := map[string]string{
+		common.TokenProgramID.ToBase58(): tokenAccountData(other, 5),
+		TOKEN_2022_PROGRAM_ID:            tokenAccountData(mint, 1234567),
+	}
+	supplyRequests := 0
+	failing := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Method string `json:"method"`
+			Params []any  `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+
+		switch {
+		case failing:
+			w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "error": {"code": -32005, "message": "Node is behind"}}`))
+		case request.Method == "getTokenSupply":
+			supplyRequests++
+			w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": {"context": {"slot": 1}, "value": {"amount": "1000", "decimals": 6, "uiAmountString": "0.001"}}}`))
+		case request.Method == "getTokenAccountsByOwner":
+			filter, _ := request.Params[1].(map[string]any)
+			data, ok := accountsByProgram[fmt.Sprint(filter["programId"])]
+			if !ok {
+				w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": {"context": {"slot": 1}, "value": []}}`))
+				return
+			}
+
+			w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": {"context": {"slot": 1}, "value": [
+				{"pubkey": "` + owner + `", "account": {"lamports": 1, "owner": "` + fmt.Sprint(filter["programId"]) + `", "data": ["` + data + `", "base64"], "executable": false, "rentEpoch": 0}}
+			]}}`))
+		default:
+			t.Fatalf("Unexpected request %+v", request)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("SOLANA_RPC_URL", server.URL)
+
+	cache := newMemoryCache(10)
+
+	balance, err := fetchSolanaAccountBalanceInternal(owner, mint, cache)
+	if err != nil || balance.String() != "1.234567" {
+		t.Fatalf("Unexpected balance %s %s", balance, err)
+	}
+
+	// the decimals are the mint's, and are only looked up once
+	if decimals, err := fetchMintDecimals(mint, cache); err != nil || decimals != 6 || supplyRequests != 1 {
+		t.Fatalf("Unexpected decimals %d %s after %d requests", decimals, err, supplyRequests)
+	}
+
+	// no account for the mint is an empty balance
+	delete(accountsByProgram, TOKEN_2022_PROGRAM_ID)
+
+	balance, err = fetchSPLBalance(owner, mint, 6)
+	if err != nil || !balance.IsZero() {
+		t.Fatalf("Expected an empty balance got %s %s", balance, err)
+	}
+
+	// but a failed lookup isn't
+	failing = true
+
+	if _, err := fetchSPLBalance(owner, mint, 6); err == nil {
+		t.Fatalf("Expected the rpc error")
+	}
+}
@@ -23,6 +23,7 @@ function setUIState(state) {
       break;
     case LOADING:
       $(".input-group").removeClass("has-error");
+      $("#error-detail").text("");
       $("#loading").show();
       $("#loaded").hide();
       $("#error").hide();
@@ -42,23 +43,26 @@ function setUIState(state) {
 
 function parseData(response) {
           // Generate CSV
-        const header = "date, earnings, tokens mined, daily price\n";
-        const csv = response
-          .data
+        const header = "date, token, earnings, tokens mined, daily price, price source\n";
+        const csv = (response.data || [])
           .map((o) => {
-            return o.date + "," + o.earnings + "," + o.tokens + "," + o.price + "\n";
+            return o.date + "," + o.token + "," + o.earnings + "," + o.tokens + "," + o.price + "," + (o.price_source || "") + "\n";
           })
-          .reduce((sum, value) => sum + value);
+          .reduce((sum, value) => sum + value, "");
 
         $("#csv-results").text(header + csv);
 
-        // Calculate total value
-        const totalEarnings = response
-          .data
-          .map((x) => x.earnings)
-          .reduce((sum, value) => sum + value);
+        // the server works out the total, already rounded to pence
+        $("#total-value").text(formatter.format(Number(response.summary.total_income)));
 
-        $("#total-value").text(formatter.format(totalEarnings));
+        const warnings = response.warnings || [];
+        const list = $("#price-warnings ul").empty();
+
+        warnings.forEach((warning) => {
+          list.append($("<li>").text(warning.date + " " + warning.token.toUpperCase() + ": " + warning.message));
+        });
+
+        $("#price-warnings").toggle(warnings.length > 0);
 }
 
 function pollForData(hntAddress, taxYear) {
@@ -66,6 +70,9 @@ function pollForData(hntAddress, taxYear) {
       .done(function(response) {
         setUIState(LOADED);
         parseData(response)
+        $("#download-csv").attr("href", "/data/" + hntAddress + ".csv?tax_year=" + taxYear);
+        $("#download-xlsx").attr("href", "/report/" + hntAddress + ".xlsx?tax_year=" + taxYear);
+        $("#download-pdf").attr("href", "/report/" + hntAddress + ".pdf?tax_year=" + taxYear);
       })
       .fail(function(jqxhr, textStatus, error) {
         // Server is not ready yet, and we should retry
@@ -75,10 +82,41 @@ function pollForData(hntAddress, taxYear) {
           return;
         }
         
+        // The job died, show why rather than polling forever
+        if (jqxhr.responseJSON && jqxhr.responseJSON.error) {
+          $("#error-detail").text(jqxhr.responseJSON.error);
+        }
+
         setUIState(FAILED);
       });
 }
 
+function renderTaxYears(response) {
+  const container = $("#tax-years");
+
+  response
+    .tax_years
+    .filter((taxYear) => taxYear.supported)
+    .forEach((taxYear) => {
+      const input = $('<input type="radio" name="tax-year">')
+        .val(taxYear.year)
+        .prop("checked", taxYear.year == response.default);
+
+      $('<label class="radio-inline"></label>')
+        .append(input)
+        .append(document.createTextNode(taxYear.label))
+        .appendTo(container);
+    });
+}
+
+function renderTaxYearsFailed() {
+  // there's nothing to ask for without a tax year, so don't offer the form
+  $("#tax-years")
+    .addClass("text-danger")
+    .text("Unable to load the tax years, please reload the page to try again.");
+  $("#submitBtn").prop("disabled", true);
+}
+
 const formatter = new Intl.NumberFormat('en-GB', {
   style: 'currency',
   currency: 'GBP'
@@ -86,6 +124,10 @@ const formatter = new Intl.NumberFormat('en-GB', {
 
 $(function() {
   setUIState(INITIAL);
+
+  $.getJSON("/tax-years")
+    .done(renderTaxYears)
+    .fail(renderTaxYearsFailed);
   
   $("#show-csv").click(() => {
     $("#csv-results").toggle();
@@ -95,7 +137,8 @@ $(function() {
     const taxYear = $("input[name=tax-year]:checked").val();
     const hntAddress = $("input#address").val();
 
-    if (!hntAddress || hntAddress.length != 51) {
+    // L1 addresses are 51 characters, solana wallets 32-44
+    if (!hntAddress || hntAddress.length < 32 || hntAddress.length > 51) {
       setUIState(BAD_ADDRESS);
       return;
     }
@@ -106,6 +149,9 @@ $(function() {
     $.getJSON("/enqueue/" + hntAddress + "?tax_year=" + taxYear)
       .done(function(response) {
          pollForData(hntAddress, taxYear)
-    });  
+      })
+      .fail(function() {
+        setUIState(FAILED);
+      });
   });
 })
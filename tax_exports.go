@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// a single reward valued at its day's price, the row every tax tool export is built from
+type RewardIncome struct {
+	Timestamp time.Time
+	Hotspot   string
+	Token     string
+	Tokens    Decimal
+	Price     Decimal
+	Value     Decimal
+	// unique per row, empty when the reward's transaction isn't known
+	TransactionId string
+}
+
+/*
+ Values each reward at the price of the day it falls on in the report,
+ so the rewards on a day add up to that day's earnings.
+*/
+func rewardIncomes(rewards []Reward, data []DataPoint) []RewardIncome {
+	priceByDay := make(map[string]Decimal)
+
+	for _, point := range data {
+		priceByDay[point.Token+"-"+point.Date] = point.Price
+	}
+
+	var incomes []RewardIncome
+
+	for _, reward := range rewards {
+		decimals, ok := rewardTokenDecimals[reward.token()]
+		if reward.Amount <= 0 || !ok {
+			continue
+		}
+
+		timestamp := time.Time(reward.Timestamp)
+		tokens := NewDecimalFromBaseUnits(reward.Amount, decimals).normalize()
+		price := priceByDay[reward.token()+"-"+dateAtStartOfDay(timestamp).Format("2006-01-02")]
+
+		incomes = append(incomes, RewardIncome{timestamp, reward.Gateway, reward.token(), tokens, price, tokens.Mul(price), reward.Hash})
+	}
+
+	sort.SliceStable(incomes, func(i, j int) bool {
+		if !incomes[i].Timestamp.Equal(incomes[j].Timestamp) {
+			return incomes[i].Timestamp.Before(incomes[j].Timestamp)
+		}
+
+		if incomes[i].Hotspot != incomes[j].Hotspot {
+			return incomes[i].Hotspot < incomes[j].Hotspot
+		}
+
+		return incomes[i].Token < incomes[j].Token
+	})
+
+	/*
+	 The tools skip rows whose id they've already imported, but one transaction
+	 pays several rewards, so every reward after the first gets a numbered id
+	 or it would be dropped as a duplicate.
+	*/
+	seen := make(map[string]int)
+
+	for i := range incomes {
+		hash := incomes[i].TransactionId
+		if hash == "" {
+			continue
+		}
+
+		seen[hash]++
+		if seen[hash] > 1 {
+			incomes[i].TransactionId = fmt.Sprintf("%s-%d", hash, seen[hash])
+		}
+	}
+
+	return incomes
+}
+
+func rewardDescription(income RewardIncome) string {
+	if income.Hotspot == "" {
+		return "Helium hotspot reward"
+	}
+
+	return fmt.Sprintf("Helium hotspot reward from %s", income.Hotspot)
+}
+
+type taxExport struct {
+	header []string
+	row    func(income RewardIncome) []string
+}
+
+/*
+ The import formats of the tax tools, picked with format=. Timestamps are
+ UTC, which is what each tool assumes when no timezone is given, and GBP
+ values are rounded to pence.
+*/
+var taxExports = map[string]taxExport{
+	// koinly's universal format
+	"koinly": {
+		header: []string{"Date", "Sent Amount", "Sent Currency", "Received Amount", "Received Currency", "Fee Amount", "Fee Currency", "Net Worth Amount", "Net Worth Currency", "Label", "Description", "TxHash"},
+		row: func(income RewardIncome) []string {
+			return []string{
+				income.Timestamp.UTC().Format("2006-01-02 15:04:05 UTC"),
+				"", "",
+				income.Tokens.String(), strings.ToUpper(income.Token),
+				"", "",
+				income.Value.RoundGBP().String(), "GBP",
+				"mining",
+				rewardDescription(income),
+				income.TransactionId,
+			}
+		},
+	},
+	// cointracking's csv import, with the value so it doesn't look up its own price
+	"cointracking": {
+		header: []string{"Type", "Buy Amount", "Buy Currency", "Sell Amount", "Sell Currency", "Fee", "Fee Currency", "Exchange", "Trade-Group", "Comment", "Date", "Tx-ID", "Buy Value in Account Currency"},
+		row: func(income RewardIncome) []string {
+			return []string{
+				"Mining",
+				income.Tokens.String(), strings.ToUpper(income.Token),
+				"", "",
+				"", "",
+				"Helium",
+				"",
+				rewardDescription(income),
+				income.Timestamp.UTC().Format("2006-01-02 15:04:05"),
+				income.TransactionId,
+				income.Value.RoundGBP().String(),
+			}
+		},
+	},
+	// recap's generic csv
+	"recap": {
+		header: []string{"Type", "Date", "InOrBuyAmount", "InOrBuyCurrency", "OutOrSellAmount", "OutOrSellCurrency", "FeeAmount", "FeeCurrency", "Value", "ValueCurrency", "Description", "TxHash"},
+		row: func(income RewardIncome) []string {
+			return []string{
+				"mining",
+				income.Timestamp.UTC().Format(time.RFC3339),
+				income.Tokens.String(), strings.ToUpper(income.Token),
+				"", "",
+				"", "",
+				income.Value.RoundGBP().String(), "GBP",
+				rewardDescription(income),
+				income.TransactionId,
+			}
+		},
+	},
+}
+
+func taxExportFormats() []string {
+	var formats []string
+
+	for format := range taxExports {
+		formats = append(formats, format)
+	}
+
+	sort.Strings(formats)
+
+	return formats
+}
+
+func writeTaxExport(w io.Writer, format string, rewards []Reward, data []DataPoint) error {
+	export, ok := taxExports[strings.ToLower(format)]
+	if !ok {
+		return fmt.Errorf("unknown format %s, use one of %s", format, strings.Join(taxExportFormats(), ", "))
+	}
+
+	writer := csv.NewWriter(w)
+
+	err := writer.Write(export.header)
+	if err != nil {
+		return err
+	}
+
+	for _, income := range rewardIncomes(rewards, data) {
+		err = writer.Write(export.row(income))
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTaxExport(t *testing.T) {
+	// 23:30 UTC on the 5th is the 6th in London, so it takes the 6th's price
+	late, _ := time.Parse(time.RFC3339, "2023-05-05T23:30:00Z")
+	early, _ := time.Parse(time.RFC3339, "2023-05-05T10:00:00Z")
+
+	rewards := []Reward{
+		{Gateway: "hotspot-a", Amount: 150000000, Timestamp: RewardTime(late), Hash: "tx-b"},
+		{Amount: 25000000, Timestamp: RewardTime(early), Hash: "tx-a"},
+		// paid by the same transaction as the first, so it needs its own id
+		{Gateway: "hotspot-b", Amount: 30000000, Timestamp: RewardTime(late), Hash: "tx-b"},
+		// mobile has 6 decimals and its own price
+		{Amount: 1500000, Timestamp: RewardTime(early.Add(time.Hour)), Token: "mobile"},
+	}
+
+	data := []DataPoint{
+		{Date: "2023-05-05", Token: "hnt", Price: d("2")},
+		{Date: "2023-05-05", Token: "mobile", Price: d("0.004")},
+		{Date: "2023-05-06", Token: "hnt", Price: d("3.333")},
+	}
+
+	var buf bytes.Buffer
+	err := writeTaxExport(&buf, "Koinly", rewards, data)
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != 5 || lines[0] != "Date,Sent Amount,Sent Currency,Received Amount,Received Currency,Fee Amount,Fee Currency,Net Worth Amount,Net Worth Currency,Label,Description,TxHash" {
+		t.Fatalf("Unexpected export %s", buf.String())
+	}
+
+	if lines[1] != "2023-05-05 10:00:00 UTC,,,0.25,HNT,,,0.50,GBP,mining,Helium hotspot reward,tx-a" {
+		t.Fatalf("Unexpected row %s", lines[1])
+	}
+
+	if lines[2] != "2023-05-05 11:00:00 UTC,,,1.5,MOBILE,,,0.01,GBP,mining,Helium hotspot reward," {
+		t.Fatalf("Unexpected row %s", lines[2])
+	}
+
+	if lines[3] != "2023-05-05 23:30:00 UTC,,,1.5,HNT,,,5.00,GBP,mining,Helium hotspot reward from hotspot-a,tx-b" {
+		t.Fatalf("Unexpected row %s", lines[3])
+	}
+
+	if lines[4] != "2023-05-05 23:30:00 UTC,,,0.3,HNT,,,1.00,GBP,mining,Helium hotspot reward from hotspot-b,tx-b-2" {
+		t.Fatalf("Unexpected row %s", lines[4])
+	}
+
+	expected := map[string][]string{
+		"cointracking": {
+			"Type,Buy Amount,Buy Currency,Sell Amount,Sell Currency,Fee,Fee Currency,Exchange,Trade-Group,Comment,Date,Tx-ID,Buy Value in Account Currency",
+			"Mining,0.25,HNT,,,,,Helium,,Helium hotspot reward,2023-05-05 10:00:00,tx-a,0.50",
+			"Mining,1.5,MOBILE,,,,,Helium,,Helium hotspot reward,2023-05-05 11:00:00,,0.01",
+			"Mining,1.5,HNT,,,,,Helium,,Helium hotspot reward from hotspot-a,2023-05-05 23:30:00,tx-b,5.00",
+			"Mining,0.3,HNT,,,,,Helium,,Helium hotspot reward from hotspot-b,2023-05-05 23:30:00,tx-b-2,1.00",
+		},
+		"recap": {
+			"Type,Date,InOrBuyAmount,InOrBuyCurrency,OutOrSellAmount,OutOrSellCurrency,FeeAmount,FeeCurrency,Value,ValueCurrency,Description,TxHash",
+			"mining,2023-05-05T10:00:00Z,0.25,HNT,,,,,0.50,GBP,Helium hotspot reward,tx-a",
+			"mining,2023-05-05T11:00:00Z,1.5,MOBILE,,,,,0.01,GBP,Helium hotspot reward,",
+			"mining,2023-05-05T23:30:00Z,1.5,HNT,,,,,5.00,GBP,Helium hotspot reward from hotspot-a,tx-b",
+			"mining,2023-05-05T23:30:00Z,0.3,HNT,,,,,1.00,GBP,Helium hotspot reward from hotspot-b,tx-b-2",
+		},
+	}
+
+	for format, rows := range expected {
+		buf.Reset()
+		if err := writeTaxExport(&buf, format, rewards, data); err != nil {
+			t.Fatalf("Failure %s", err)
+		}
+
+		lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != len(rows) {
+			t.Fatalf("Unexpected %s export %s", format, buf.String())
+		}
+
+		for i, row := range rows {
+			if lines[i] != row {
+				t.Fatalf("Unexpected %s row %s, expected %s", format, lines[i], row)
+			}
+		}
+	}
+
+	if err := writeTaxExport(&buf, "turbotax", rewards, data); err == nil {
+		t.Fatalf("Expected an unknown format")
+	}
+}
+
+func TestRewardJSON(t *testing.T) {
+	timestamp, _ := time.Parse(time.RFC3339, "2023-05-05T23:30:00Z")
+
+	encoded, _ := json.Marshal([]Reward{{Gateway: "a", Amount: 1, Timestamp: RewardTime(timestamp)}})
+
+	var decoded []Reward
+	err := json.Unmarshal(encoded, &decoded)
+
+	if err != nil || !time.Time(decoded[0].Timestamp).Equal(timestamp) || decoded[0].Gateway != "a" {
+		t.Fatalf("Unexpected round trip %s %s", encoded, err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+type TaxRates struct {
+	PersonalAllowance       float64 `json:"personal_allowance"`
+	BasicRateBand           float64 `json:"basic_rate_band"`
+	AdditionalRateThreshold float64 `json:"additional_rate_threshold"`
+	IncomeTaxBasicRate      float64 `json:"income_tax_basic_rate"`
+	IncomeTaxHigherRate     float64 `json:"income_tax_higher_rate"`
+	IncomeTaxAdditionalRate float64 `json:"income_tax_additional_rate"`
+	// the £1,000 trading and miscellaneous income allowance
+	TradingAllowance   float64 `json:"trading_allowance"`
+	CgtAnnualExemption float64 `json:"cgt_annual_exemption"`
+	CgtBasicRate       float64 `json:"cgt_basic_rate"`
+	CgtHigherRate      float64 `json:"cgt_higher_rate"`
+	// rates that took over part way through the year
+	CgtRateChanges []CgtRateChange `json:"cgt_rate_changes,omitempty"`
+}
+
+// the CGT rates for disposals on or after From, a YYYY-MM-DD date
+type CgtRateChange struct {
+	From          string  `json:"from"`
+	CgtBasicRate  float64 `json:"cgt_basic_rate"`
+	CgtHigherRate float64 `json:"cgt_higher_rate"`
+}
+
+// the basic and higher CGT rates for a disposal on date, a YYYY-MM-DD date
+func (rates TaxRates) cgtRatesOn(date string) (float64, float64) {
+	basic, higher := rates.CgtBasicRate, rates.CgtHigherRate
+
+	for _, change := range rates.CgtRateChanges {
+		// the dates sort as strings
+		if date >= change.From {
+			basic, higher = change.CgtBasicRate, change.CgtHigherRate
+		}
+	}
+
+	return basic, higher
+}
+
+// Year is the calendar year the tax year starts in, i.e. 2023 for 2023/2024
+type TaxYear struct {
+	Year         int      `json:"year"`
+	Label        string   `json:"label"`
+	Start        string   `json:"start"`
+	End          string   `json:"end"`
+	Jurisdiction string   `json:"jurisdiction"`
+	Timezone     string   `json:"timezone"`
+	Supported    bool     `json:"supported"`
+	Rates        TaxRates `json:"rates"`
+}
+
+var ukRates2020 = TaxRates{
+	PersonalAllowance:       12500,
+	BasicRateBand:           37500,
+	AdditionalRateThreshold: 150000,
+	IncomeTaxBasicRate:      0.20,
+	IncomeTaxHigherRate:     0.40,
+	IncomeTaxAdditionalRate: 0.45,
+	TradingAllowance:        1000,
+	CgtAnnualExemption:      12300,
+	CgtBasicRate:            0.10,
+	CgtHigherRate:           0.20,
+}
+
+func withRates(rates TaxRates, change func(*TaxRates)) TaxRates {
+	change(&rates)
+	return rates
+}
+
+var ukRates2021 = withRates(ukRates2020, func(r *TaxRates) {
+	r.PersonalAllowance = 12570
+	r.BasicRateBand = 37700
+})
+
+var ukRates2023 = withRates(ukRates2021, func(r *TaxRates) {
+	r.AdditionalRateThreshold = 125140
+	r.CgtAnnualExemption = 6000
+})
+
+// the CGT rates went up to 18% and 24% part way through, from 30 October 2024
+var ukRates2024 = withRates(ukRates2023, func(r *TaxRates) {
+	r.CgtAnnualExemption = 3000
+	r.CgtRateChanges = []CgtRateChange{{From: "2024-10-30", CgtBasicRate: 0.18, CgtHigherRate: 0.24}}
+})
+
+var ukRates2025 = withRates(ukRates2024, func(r *TaxRates) {
+	r.CgtBasicRate = 0.18
+	r.CgtHigherRate = 0.24
+	r.CgtRateChanges = nil
+})
+
+func ukTaxYear(year int, supported bool, rates TaxRates) TaxYear {
+	return TaxYear{
+		Year:         year,
+		Label:        fmt.Sprintf("%d/%d", year, year+1),
+		Start:        fmt.Sprintf("%d-04-06", year),
+		End:          fmt.Sprintf("%d-04-05", year+1),
+		Jurisdiction: "GB",
+		Timezone:     "Europe/London",
+		Supported:    supported,
+		Rates:        rates,
+	}
+}
+
+// a year isn't supported until it has finished and its rates are known
+var taxYears = []TaxYear{
+	ukTaxYear(2020, true, ukRates2020),
+	ukTaxYear(2021, true, ukRates2021),
+	ukTaxYear(2022, true, ukRates2021),
+	ukTaxYear(2023, true, ukRates2023),
+	ukTaxYear(2024, true, ukRates2024),
+	ukTaxYear(2025, true, ukRates2025),
+	ukTaxYear(2026, false, ukRates2025),
+}
+
+// replaces the built in table with a JSON array of tax years
+func loadTaxYears(path string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var loaded []TaxYear
+	err = json.Unmarshal(contents, &loaded)
+	if err != nil {
+		return err
+	}
+
+	for _, taxYear := range loaded {
+		_, _, err := taxYear.bounds()
+		if err != nil {
+			return fmt.Errorf("tax year %d %s", taxYear.Year, err)
+		}
+	}
+
+	sort.SliceStable(loaded, func(i, j int) bool {
+		return loaded[i].Year < loaded[j].Year
+	})
+
+	taxYears = loaded
+
+	return nil
+}
+
+func findTaxYear(year int) (TaxYear, bool) {
+	for _, taxYear := range taxYears {
+		if taxYear.Year == year {
+			return taxYear, true
+		}
+	}
+
+	return TaxYear{}, false
+}
+
+func latestSupportedTaxYear() int {
+	latest := 0
+
+	for _, taxYear := range taxYears {
+		if taxYear.Supported && taxYear.Year > latest {
+			latest = taxYear.Year
+		}
+	}
+
+	return latest
+}
+
+// the start of the first day and the start of the day after the last day
+func (taxYear TaxYear) bounds() (time.Time, time.Time, error) {
+	tz, err := time.LoadLocation(taxYear.Timezone)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	start, err := time.ParseInLocation("2006-01-02", taxYear.Start, tz)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	end, err := time.ParseInLocation("2006-01-02", taxYear.End, tz)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("ends before it starts")
+	}
+
+	return start, end.AddDate(0, 0, 1), nil
+}
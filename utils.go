@@ -3,155 +3,271 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/memcachier/mc"
-	"io/ioutil"
 	"log"
-	"math"
-	"net/http"
 	"sort"
 	"strconv"
 	"time"
+	// the Europe/London rules are needed even where the host has no zoneinfo
+	_ "time/tzdata"
 )
 
-type DataPoint struct {
-	Date     string  `json:"date"`
-	Earnings float64 `json:"earnings"`
-	Tokens   float64 `json:"tokens"`
-	Price    float64 `json:"price"`
-}
-
-func fetchUrl(url string, cache *mc.Client) []byte {
-    // Add a delay to all requests
-    time.Sleep(250 * time.Millisecond)
-	return fetchUrlWithRetry(url, cache, false)
-}
-
-func fetchUrlWithRetry(url string, cache *mc.Client, isRetry bool) []byte {
-	val, _, _, cacheReadErr := cache.Get(url)
-	if cacheReadErr != nil {
-	} else {
-		log.Printf("Cache Hit: %s", url)
-		return []byte(val)
-	}
+const DEFAULT_REPORT_TIMEZONE = "Europe/London"
 
-	client := http.Client{}
+// rewards and prices are bucketed into calendar days in this timezone
+var reportLocation, _ = time.LoadLocation(DEFAULT_REPORT_TIMEZONE)
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+func setReportTimezone(name string) error {
+	location, err := time.LoadLocation(name)
 	if err != nil {
-		log.Println("Failed to create request")
-		log.Println(err)
-		return []byte{}
-	}
-
-	req.Header.Add("User-Agent", "hnt-hmrc")
-
-	res, getErr := client.Do(req)
-	if getErr != nil {
-		log.Println("Request failed")
-		log.Println(getErr)
-		return []byte{}
+		return err
 	}
 
-	log.Printf("%d - %s\n", res.StatusCode, url)
-	if res.StatusCode != 200 && isRetry == false {
-		log.Println("Retrying in 2 sec")
-		time.Sleep(2 * time.Second)
-		return fetchUrlWithRetry(url, cache, true)
-	}
-
-	if res.Body != nil {
-		defer res.Body.Close()
-	}
+	reportLocation = location
 
-	body, readErr := ioutil.ReadAll(res.Body)
-	if readErr != nil {
-		log.Println("Failed to extract response")
-		log.Println(readErr)
-		return []byte{}
-	}
+	return nil
+}
 
-	_, cacheWriteErr := cache.Set(url, string(body), 0, URL_CACHE_TTL, 0)
-	if cacheWriteErr != nil {
-		log.Printf("Failed to cache %s\n", url)
-		log.Println("Cache write error: ", cacheWriteErr)
-	}
+/*
+ A day's rewards in one token. Tokens is exactly BaseUnits shifted by the
+ token's decimals and Earnings is exactly Tokens * Price, nothing is
+ rounded until a total is reported.
+*/
+type DataPoint struct {
+	Date      string  `json:"date"`
+	Token     string  `json:"token"`
+	Earnings  Decimal `json:"earnings"`
+	Tokens    Decimal `json:"tokens"`
+	Price     Decimal `json:"price"`
+	BaseUnits int64   `json:"base_units"`
+	// the provider the day's price came from
+	PriceSource string            `json:"price_source"`
+	PriceStatus PriceStatus       `json:"price_status"`
+	Hotspots    []HotspotEarnings `json:"hotspots,omitempty"`
+}
 
-	return body
+// a hotspot's share of a day's DataPoint, at the same price
+type HotspotEarnings struct {
+	Hotspot   string  `json:"hotspot"`
+	BaseUnits int64   `json:"base_units"`
+	Tokens    Decimal `json:"tokens"`
+	Earnings  Decimal `json:"earnings"`
 }
 
+// midnight of the day date falls on in the report timezone, the key for a day's rewards and price
 func dateAtStartOfDay(date time.Time) time.Time {
-	year, month, day := time.Time(date).Date()
+	year, month, day := date.In(reportLocation).Date()
 
-	key := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	key := time.Date(year, month, day, 0, 0, 0, 0, reportLocation)
 
 	return key
 }
 
-func parseTaxYear(taxYear string) (int, error) {
-	value, err := strconv.Atoi(taxYear)
+func parseTaxYear(input string) (int, error) {
+	value, err := strconv.Atoi(input)
 
 	if err != nil {
 		return 0, err
 	}
 
-	if value < MIN_YEAR || value > MAX_YEAR {
+	taxYear, ok := findTaxYear(value)
+
+	if !ok || !taxYear.Supported {
 		return 0, fmt.Errorf("%d is not a supported tax year", value)
 	}
 
 	return value, nil
 }
 
+// the days depend on the timezone, so it is part of the key
 func cacheKey(address string, taxYear int) string {
-	return fmt.Sprintf("v1-%s-%d", address, taxYear)
+	return fmt.Sprintf("v7-%s-%d-%s", address, taxYear, reportLocation)
 }
 
-func getDataByAddress(address string, cache *mc.Client, startTime time.Time, endTime time.Time) []DataPoint {
+func rewardsCacheKey(address string, taxYear int) string {
+	return fmt.Sprintf("v5-rewards-%s-%d", address, taxYear)
+}
+
+// a token's price for every day in the range, with any gaps filled
+func filledPrices(token string, cache Cache, startTime time.Time, endTime time.Time) (PriceQuotesByTime, error) {
+	quotes, err := getMarketData(token, cache, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return defaultGapFilling.fill(quotes, daysInRange(startTime, endTime)), nil
+}
+
+// the daily data for each token, along with the individual rewards it was built from
+func getDataByAddress(address string, cache Cache, startTime time.Time, endTime time.Time) ([]DataPoint, []Reward, error) {
 	var data []DataPoint
 
-	priceData := getMarketData(cache, startTime, endTime)
-	earnings := rewardsByDay(address, cache, startTime, endTime)
+	rewards, rewardsErr := fetchRewardsInRange(address, cache, startTime, endTime)
+	if rewardsErr != nil {
+		return nil, nil, rewardsErr
+	}
 
-	for date, earnt := range earnings {
-		coinPrice := priceData[date]
+	for token, earnings := range groupRewardsByDay(rewards) {
+		decimals, ok := rewardTokenDecimals[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown reward token %s", token)
+		}
 
-		roundedEarnings := earnt / math.Pow(10, 8)
-		formattedDate := date.Format("2006-01-02")
+		// each token is valued against its own price history
+		priceData, priceErr := filledPrices(token, cache, startTime, endTime)
+		if priceErr != nil {
+			return nil, nil, priceErr
+		}
 
-		entry := DataPoint{
-			formattedDate,
-			(coinPrice * roundedEarnings),
-			roundedEarnings,
-			coinPrice,
+		for date, earnt := range earnings {
+			quote, ok := priceData[date]
+			if !ok {
+				quote = PriceQuote{Status: PriceMissing}
+			}
+
+			tokens := NewDecimalFromBaseUnits(earnt.Total, decimals)
+
+			data = append(data, DataPoint{
+				Date:        date.Format("2006-01-02"),
+				Token:       token,
+				Earnings:    tokens.Mul(quote.Price),
+				Tokens:      tokens,
+				Price:       quote.Price,
+				BaseUnits:   earnt.Total,
+				PriceSource: quote.Source,
+				PriceStatus: quote.Status,
+				Hotspots:    hotspotEarnings(earnt, decimals, quote.Price),
+			})
 		}
+	}
 
-		data = append(data, entry)
+	sortDataPoints(data)
 
-		sort.SliceStable(data, func(i, j int) bool {
+	return data, rewards, nil
+}
+
+// by date, and the tokens on a day in the order of rewardTokens
+func sortDataPoints(data []DataPoint) {
+	sort.SliceStable(data, func(i, j int) bool {
+		if data[i].Date != data[j].Date {
 			return data[i].Date < data[j].Date
-		})
+		}
+
+		return tokenIndex(data[i].Token) < tokenIndex(data[j].Token)
+	})
+}
+
+func tokenIndex(token string) int {
+	for i, rewardToken := range rewardTokens {
+		if rewardToken == token {
+			return i
+		}
 	}
 
-	return data
+	return len(rewardTokens)
 }
 
-func fetchData(address string, taxYear int, cache *mc.Client) {
+func hotspotEarnings(earnt *DayEarnings, decimals uint8, price Decimal) []HotspotEarnings {
+	var hotspots []HotspotEarnings
+
+	for hotspot, units := range earnt.ByHotspot {
+		tokens := NewDecimalFromBaseUnits(units, decimals)
+
+		hotspots = append(hotspots, HotspotEarnings{hotspot, units, tokens, tokens.Mul(price)})
+	}
+
+	sort.SliceStable(hotspots, func(i, j int) bool {
+		return hotspots[i].Hotspot < hotspots[j].Hotspot
+	})
+
+	return hotspots
+}
+
+// years missing from the tax year table fall back to the UK's 6th of April to 5th of April
+func taxYearBounds(taxYear int) (time.Time, time.Time) {
+	if entry, ok := findTaxYear(taxYear); ok {
+		start, end, err := entry.bounds()
+		if err == nil {
+			return start, end
+		}
+	}
+
 	tz, _ := time.LoadLocation("Europe/London")
 	start := time.Date(taxYear, 4, 6, 0, 0, 0, 0, tz)
 	end := time.Date(taxYear+1, 4, 6, 0, 0, 0, 0, tz)
 
+	return start, end
+}
+
+func taxYearOf(date time.Time) int {
+	start, _ := taxYearBounds(date.Year())
+
+	if date.Before(start) {
+		return date.Year() - 1
+	}
+
+	return date.Year()
+}
+
+func loadCachedData(address string, taxYear int, cache Cache) ([]DataPoint, error) {
+	cachedData, cacheReadErr := cache.Get(cacheKey(address, taxYear))
+	if cacheReadErr != nil {
+		return nil, cacheReadErr
+	}
+
+	var data []DataPoint
+	err := json.Unmarshal([]byte(cachedData), &data)
+
+	return data, err
+}
+
+func loadCachedRewards(address string, taxYear int, cache Cache) ([]Reward, error) {
+	cachedData, cacheReadErr := cache.Get(rewardsCacheKey(address, taxYear))
+	if cacheReadErr != nil {
+		return nil, cacheReadErr
+	}
+
+	var rewards []Reward
+	err := json.Unmarshal([]byte(cachedData), &rewards)
+
+	return rewards, err
+}
+
+func fetchData(address string, taxYear int, cache Cache) error {
+	start, end := taxYearBounds(taxYear)
+
 	log.Printf("Fetching data ... %s\n", cacheKey(address, taxYear))
-	data := getDataByAddress(address, cache, start, end)
+	data, rewards, err := getDataByAddress(address, cache, start, end)
+	if err != nil {
+		log.Printf("Failed to fetch data %s %s", cacheKey(address, taxYear), err)
+		return err
+	}
+
+	// the exports need each reward, the rewards go in first so they're there whenever the data is
+	rewardsData, err := json.Marshal(rewards)
+	if err != nil {
+		return err
+	}
+
+	cacheError := cache.Set(rewardsCacheKey(address, taxYear), string(rewardsData), RESULT_CACHE_TTL)
+	if cacheError != nil {
+		log.Printf("Cache failure %s %s", rewardsCacheKey(address, taxYear), cacheError)
+		return cacheError
+	}
 
 	jsonData, err := json.Marshal(data)
 
 	if err != nil {
 		log.Printf("Failed to serialize JSON for cache %s", cacheKey(address, taxYear))
+		return err
 	}
 
-	_, cacheError := cache.Set(cacheKey(address, taxYear), string(jsonData), 0, RESULT_CACHE_TTL, 0)
+	cacheError = cache.Set(cacheKey(address, taxYear), string(jsonData), RESULT_CACHE_TTL)
 	if cacheError != nil {
 		log.Printf("Cache failure %s %s", cacheKey(address, taxYear), cacheError)
+		return cacheError
 	}
 
 	log.Printf("Caching data %s", cacheKey(address, taxYear))
+
+	return nil
 }
@@ -1,17 +1,119 @@
 package main
 
 import (
-    "github.com/memcachier/mc"
+    "errors"
+    "net/http"
+    "net/http/httptest"
     "testing"
+    "time"
 )
 
 func TestFetch(t *testing.T) {
+    requests := 0
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        requests++
+        w.Write([]byte("hello"))
+    }))
+    defer server.Close()
 
-    url := "http://example.com"
-    cache := mc.Client {}
-    result := fetchUrl(url, &cache)
+    url := server.URL
+    cache := newMemoryCache(10)
+    result, err := fetchUrl(url, cache)
 
-    if result == nil {
+    if err != nil || result == nil {
         t.Fatalf("Failure")
     }
-}
\ No newline at end of file
+
+    // the second fetch should come from the cache
+    result, err = fetchUrl(url, cache)
+
+    if err != nil || string(result) != "hello" || requests != 1 {
+        t.Fatalf("Failure")
+    }
+}
+
+func TestFetchJsonOnlyCachesValidBodies(t *testing.T) {
+    responses := []string{"<html>busy</html>", `{"prices": []}`, `{"prices": [[1, 2]]}`}
+    requests := 0
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(responses[requests]))
+        requests++
+    }))
+    defer server.Close()
+
+    cache := newMemoryCache(10)
+
+    var chart MarketChart
+    valid := func() error {
+        if len(chart.Prices) == 0 {
+            return errors.New("no prices")
+        }
+
+        return nil
+    }
+
+    // neither a body that isn't json nor one without any prices is kept
+    for range 2 {
+        if err := fetchValidJson(server.URL, cache, &chart, valid); err == nil {
+            t.Fatalf("Expected an error")
+        }
+
+        if _, err := cache.Get(server.URL); err == nil {
+            t.Fatalf("A bad response was cached")
+        }
+    }
+
+    if err := fetchValidJson(server.URL, cache, &chart, valid); err != nil || len(chart.Prices) != 1 {
+        t.Fatalf("Failure %s", err)
+    }
+
+    // the good one comes from the cache
+    if err := fetchValidJson(server.URL, cache, &chart, valid); err != nil || requests != 3 {
+        t.Fatalf("Expected 3 requests got %d %s", requests, err)
+    }
+}
+
+func TestParseTaxYear(t *testing.T) {
+    if year, err := parseTaxYear("2023"); err != nil || year != 2023 {
+        t.Fatalf("Failure")
+    }
+
+    // before our time, and not finished yet
+    for _, input := range []string{"2019", "2026", "abc"} {
+        if _, err := parseTaxYear(input); err == nil {
+            t.Fatalf("Expected %s to be rejected", input)
+        }
+    }
+}
+
+func TestTaxYearBounds(t *testing.T) {
+    start, end := taxYearBounds(2023)
+
+    if start.Format(time.RFC3339) != "2023-04-06T00:00:00+01:00" || end.Format(time.RFC3339) != "2024-04-06T00:00:00+01:00" {
+        t.Fatalf("Unexpected bounds %s %s", start, end)
+    }
+
+    if taxYearOf(end.Add(-time.Second)) != 2023 || taxYearOf(end) != 2024 {
+        t.Fatalf("Failure")
+    }
+}
+
+func TestDateAtStartOfDay(t *testing.T) {
+    // half past midnight BST on the first day of the 2023/2024 tax year
+    reward, _ := time.Parse(time.RFC3339, "2023-04-05T23:30:00Z")
+    day := dateAtStartOfDay(reward)
+
+    if day.Format("2006-01-02") != "2023-04-06" || taxYearOf(day) != 2023 {
+        t.Fatalf("Unexpected day %s", day)
+    }
+
+    // the same day from another instant is the same map key
+    if dateAtStartOfDay(reward.Add(12*time.Hour)) != day {
+        t.Fatalf("Expected the same key")
+    }
+
+    start, _ := taxYearBounds(2023)
+    if !dateAtStartOfDay(start).Equal(start) {
+        t.Fatalf("Expected the tax year to start at midnight %s", start)
+    }
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ A minimal SpreadsheetML writer, just enough for the report: strings are
+ written inline so there's no shared string table, and every cell points
+ at one of the fixed styles below.
+ https://learn.microsoft.com/en-us/office/open-xml/spreadsheet/structure-of-a-spreadsheetml-document
+*/
+
+const XLSX_CONTENT_TYPE = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// indexes into cellXfs in xlsxStyles
+const (
+	xlsxStyleDefault = iota
+	xlsxStyleGBP
+	xlsxStyleTokens
+	xlsxStyleDate
+	xlsxStyleHeader
+	xlsxStylePrice
+	xlsxStyleMonth
+)
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="4">
+<numFmt numFmtId="164" formatCode="[$£-809]#,##0.00"/>
+<numFmt numFmtId="165" formatCode="0.00000000"/>
+<numFmt numFmtId="166" formatCode="[$£-809]#,##0.0000"/>
+<numFmt numFmtId="167" formatCode="mmm yyyy"/>
+</numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="7">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+<xf numFmtId="166" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="167" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+</cellXfs>
+</styleSheet>`
+
+type xlsxCell struct {
+	// a number, or the text of a string cell
+	value    string
+	isNumber bool
+	formula  string
+	style    int
+}
+
+// a file inside the workbook's zip
+type xlsxPart struct {
+	name string
+	body string
+}
+
+type xlsxSheet struct {
+	name   string
+	widths []float64
+	rows   [][]xlsxCell
+}
+
+func xlsxString(value string) xlsxCell {
+	return xlsxCell{value: value}
+}
+
+func xlsxHeader(value string) xlsxCell {
+	return xlsxCell{value: value, style: xlsxStyleHeader}
+}
+
+func xlsxNumber(value Decimal, style int) xlsxCell {
+	return xlsxCell{value: value.String(), isNumber: true, style: style}
+}
+
+// spreadsheets count days from 30 December 1899
+func xlsxDate(date string, style int) xlsxCell {
+	parsed, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return xlsxString(date)
+	}
+
+	days := int64(parsed.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+
+	return xlsxCell{value: fmt.Sprintf("%d", days), isNumber: true, style: style}
+}
+
+func xlsxSum(column string, firstRow int, lastRow int, total Decimal, style int) xlsxCell {
+	cell := xlsxNumber(total, style)
+
+	if lastRow >= firstRow {
+		cell.formula = fmt.Sprintf("SUM(%s%d:%s%d)", column, firstRow, column, lastRow)
+	}
+
+	return cell
+}
+
+// 0 is A, 26 is AA
+func xlsxColumn(index int) string {
+	name := ""
+
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+
+	return name
+}
+
+func xmlEscape(value string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(value))
+
+	return buf.String()
+}
+
+func (sheet xlsxSheet) xml() string {
+	var buf strings.Builder
+
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`)
+	buf.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+
+	if len(sheet.widths) > 0 {
+		buf.WriteString("<cols>")
+		for i, width := range sheet.widths {
+			fmt.Fprintf(&buf, `<col min="%d" max="%d" width="%g" customWidth="1"/>`, i+1, i+1, width)
+		}
+		buf.WriteString("</cols>")
+	}
+
+	buf.WriteString("<sheetData>")
+
+	for r, row := range sheet.rows {
+		fmt.Fprintf(&buf, `<row r="%d">`, r+1)
+
+		for c, cell := range row {
+			ref := fmt.Sprintf("%s%d", xlsxColumn(c), r+1)
+
+			switch {
+			case cell.value == "" && !cell.isNumber:
+				continue
+			case cell.isNumber:
+				fmt.Fprintf(&buf, `<c r="%s" s="%d">`, ref, cell.style)
+				if cell.formula != "" {
+					fmt.Fprintf(&buf, "<f>%s</f>", xmlEscape(cell.formula))
+				}
+				fmt.Fprintf(&buf, "<v>%s</v></c>", cell.value)
+			default:
+				fmt.Fprintf(&buf, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, cell.style, xmlEscape(cell.value))
+			}
+		}
+
+		buf.WriteString("</row>")
+	}
+
+	buf.WriteString("</sheetData></worksheet>")
+
+	return buf.String()
+}
+
+func writeXLSX(w io.Writer, sheets []xlsxSheet) error {
+	var files []xlsxPart
+
+	add := func(name string, body string) {
+		files = append(files, xlsxPart{name, body})
+	}
+
+	var overrides, sheetEntries, relationships strings.Builder
+
+	for i, sheet := range sheets {
+		fmt.Fprintf(&overrides, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+		fmt.Fprintf(&sheetEntries, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sheet.name), i+1, i+1)
+		fmt.Fprintf(&relationships, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+
+	fmt.Fprintf(&relationships, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(sheets)+1)
+
+	add("[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`+
+		`<Default Extension="xml" ContentType="application/xml"/>`+
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`+
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`+
+		overrides.String()+
+		`</Types>`)
+
+	add("_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>`+
+		`</Relationships>`)
+
+	add("xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">`+
+		`<sheets>`+sheetEntries.String()+`</sheets>`+
+		`</workbook>`)
+
+	add("xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+		relationships.String()+
+		`</Relationships>`)
+
+	add("xl/styles.xml", xlsxStyles)
+
+	for i, sheet := range sheets {
+		add(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), sheet.xml())
+	}
+
+	archive := zip.NewWriter(w)
+
+	for _, file := range files {
+		writer, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+
+		_, err = writer.Write([]byte(file.body))
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// the distinct price sources used, in the order they first appear
+func priceSources(data []DataPoint) []string {
+	var sources []string
+
+	for _, point := range data {
+		if point.PriceSource != "" && !containsString(sources, point.PriceSource) {
+			sources = append(sources, point.PriceSource)
+		}
+	}
+
+	return sources
+}
+
+/*
+ The workbook has a Daily sheet with a row per earning day, a Monthly
+ sheet of subtotals and a Metadata sheet saying where the numbers came
+ from. Earnings cells hold the exact value and display rounded to pence,
+ so the totals are sums of exact values just like the report summary.
+*/
+func writeReportXLSX(w io.Writer, address string, taxYear int, data []DataPoint, generatedAt time.Time) error {
+	summary := summariseReport(taxYear, data)
+
+	daily := xlsxSheet{
+		name:   "Daily",
+		widths: []float64{12, 10, 16, 14, 16, 16, 16},
+		rows: [][]xlsxCell{{
+			xlsxHeader("Date"),
+			xlsxHeader("Token"),
+			xlsxHeader("Amount"),
+			xlsxHeader("Price (GBP)"),
+			xlsxHeader("Earnings (GBP)"),
+			xlsxHeader("Price Source"),
+			xlsxHeader("Price Status"),
+		}},
+	}
+
+	for _, point := range data {
+		daily.rows = append(daily.rows, []xlsxCell{
+			xlsxDate(point.Date, xlsxStyleDate),
+			xlsxString(strings.ToUpper(point.Token)),
+			xlsxNumber(point.Tokens, xlsxStyleTokens),
+			xlsxNumber(point.Price, xlsxStylePrice),
+			xlsxNumber(point.Earnings, xlsxStyleGBP),
+			xlsxString(point.PriceSource),
+			xlsxString(string(point.PriceStatus)),
+		})
+	}
+
+	lastDay := len(daily.rows)
+	totals := []xlsxCell{xlsxHeader("Total"), {}, {}}
+
+	// amounts of different tokens can't be added up
+	if token, ok := summary.singleToken(); ok {
+		totals[1] = xlsxString(strings.ToUpper(token.Token))
+		totals[2] = xlsxSum("C", 2, lastDay, token.Tokens, xlsxStyleTokens)
+	}
+
+	daily.rows = append(daily.rows, append(totals, xlsxCell{}, xlsxSum("E", 2, lastDay, summary.TotalIncomeExact, xlsxStyleGBP)))
+
+	// a column of tokens earned for each token, then the combined income
+	monthly := xlsxSheet{
+		name:   "Monthly",
+		widths: []float64{12},
+		rows:   [][]xlsxCell{{xlsxHeader("Month")}},
+	}
+
+	for _, token := range summary.Tokens {
+		monthly.widths = append(monthly.widths, 16)
+		monthly.rows[0] = append(monthly.rows[0], xlsxHeader(strings.ToUpper(token.Token)))
+	}
+
+	monthly.widths = append(monthly.widths, 16, 14)
+	monthly.rows[0] = append(monthly.rows[0], xlsxHeader("Earnings (GBP)"), xlsxHeader("Earning Days"))
+
+	for _, month := range summary.Months {
+		row := []xlsxCell{xlsxDate(month.Month+"-01", xlsxStyleMonth)}
+
+		for _, token := range summary.Tokens {
+			row = append(row, xlsxNumber(month.Tokens[token.Token], xlsxStyleTokens))
+		}
+
+		monthly.rows = append(monthly.rows, append(row,
+			xlsxNumber(month.Income, xlsxStyleGBP),
+			xlsxNumber(NewDecimal(int64(month.EarningDays), 0), xlsxStyleDefault),
+		))
+	}
+
+	totals = []xlsxCell{xlsxHeader("Total")}
+
+	for _, token := range summary.Tokens {
+		totals = append(totals, xlsxNumber(token.Tokens, xlsxStyleTokens))
+	}
+
+	monthly.rows = append(monthly.rows, append(totals,
+		xlsxNumber(summary.TotalIncome, xlsxStyleGBP),
+		xlsxNumber(NewDecimal(int64(summary.EarningDays), 0), xlsxStyleDefault),
+	))
+
+	label := fmt.Sprintf("%d/%d", taxYear, taxYear+1)
+	if entry, ok := findTaxYear(taxYear); ok {
+		label = entry.Label
+	}
+
+	start, end := taxYearBounds(taxYear)
+
+	sources := priceSources(data)
+	sort.Strings(sources)
+
+	metadata := xlsxSheet{
+		name:   "Metadata",
+		widths: []float64{20, 60},
+		rows: [][]xlsxCell{
+			{xlsxHeader("Address"), xlsxString(address)},
+			{xlsxHeader("Tax Year"), xlsxString(label)},
+			{xlsxHeader("Period"), xlsxString(fmt.Sprintf("%s to %s", start.Format("2006-01-02"), end.AddDate(0, 0, -1).Format("2006-01-02")))},
+			{xlsxHeader("Timezone"), xlsxString(reportLocation.String())},
+			{xlsxHeader("Price Source"), xlsxString(strings.Join(sources, ", "))},
+			{xlsxHeader("Price Gaps"), xlsxString(fmt.Sprintf("%s, up to %d days", defaultGapFilling.Policy, defaultGapFilling.MaxDays))},
+			{xlsxHeader("Warnings"), xlsxNumber(NewDecimal(int64(len(priceWarnings(data))), 0), xlsxStyleDefault)},
+			{xlsxHeader("Generated"), xlsxString(generatedAt.UTC().Format(time.RFC3339))},
+		},
+	}
+
+	return writeXLSX(w, []xlsxSheet{daily, monthly, metadata})
+}
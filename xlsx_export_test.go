@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteReportXLSX(t *testing.T) {
+	data := []DataPoint{
+		{Date: "2023-05-01", Token: "hnt", Earnings: d("1.005"), Tokens: d("0.5"), Price: d("2.01"), PriceSource: "coingecko", PriceStatus: PriceExact},
+		{Date: "2023-06-02", Token: "iot", Earnings: d("2"), Tokens: d("1"), Price: d("2"), PriceSource: "<cryptocompare>", PriceStatus: PriceInterpolated},
+	}
+
+	var buf bytes.Buffer
+	err := writeReportXLSX(&buf, "wallet", 2023, data, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failure %s", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Not a zip %s", err)
+	}
+
+	parts := make(map[string]string)
+
+	for _, file := range archive.File {
+		reader, _ := file.Open()
+		body, _ := io.ReadAll(reader)
+		reader.Close()
+
+		// every part has to be well formed or Excel refuses the whole workbook
+		decoder := xml.NewDecoder(bytes.NewReader(body))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s is not well formed %s", file.Name, err)
+			}
+		}
+
+		parts[file.Name] = string(body)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/styles.xml", "xl/worksheets/sheet3.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Fatalf("Missing %s", name)
+		}
+	}
+
+	daily := parts["xl/worksheets/sheet1.xml"]
+
+	// 2023-05-01 is day 45047, earnings are numbers in the GBP style
+	if !strings.Contains(daily, `<c r="A2" s="3"><v>45047</v></c>`) || !strings.Contains(daily, `<c r="E2" s="1"><v>1.005</v></c>`) {
+		t.Fatalf("Unexpected daily sheet %s", daily)
+	}
+
+	if !strings.Contains(daily, `<f>SUM(E2:E3)</f><v>3.005</v>`) || !strings.Contains(daily, "&lt;cryptocompare&gt;") {
+		t.Fatalf("Unexpected daily sheet %s", daily)
+	}
+
+	// a column per token on the monthly sheet
+	monthly := parts["xl/worksheets/sheet2.xml"]
+	if !strings.Contains(monthly, ">HNT<") || !strings.Contains(monthly, ">IOT<") || !strings.Contains(monthly, `<c r="C4" s="2"><v>1</v></c>`) {
+		t.Fatalf("Unexpected monthly sheet %s", monthly)
+	}
+
+	if !strings.Contains(parts["xl/worksheets/sheet3.xml"], "2024-05-01T12:00:00Z") {
+		t.Fatalf("Missing the generation time")
+	}
+
+	if xlsxColumn(0) != "A" || xlsxColumn(26) != "AA" || xlsxColumn(51) != "AZ" {
+		t.Fatalf("Unexpected column names")
+	}
+}